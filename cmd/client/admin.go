@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func handlerAnnouncement(gs *gamelogic.GameState) func(gamelogic.Announcement) routing.AckType {
+	return func(a gamelogic.Announcement) routing.AckType {
+		defer fmt.Print("> ")
+		gs.HandleAnnouncement(a)
+		return routing.Ack
+	}
+}
+
+// handlerKick disconnects us when the server kicks or bans us, deleting our
+// queues on the way out.
+func handlerKick(gs *gamelogic.GameState, conn *amqp.Connection, gameID string) func(gamelogic.Kick) routing.AckType {
+	return func(k gamelogic.Kick) routing.AckType {
+		gs.HandleKick(k)
+		deleteQueues(conn, gameID, gs.GetUsername())
+		cleanup()
+		os.Exit(1)
+		return routing.Ack
+	}
+}
+
+// deleteQueues deletes every queue this client declared for itself.
+func deleteQueues(conn *amqp.Connection, gameID, username string) {
+	ch, err := conn.Channel()
+	if err != nil {
+		log.Printf("Failed to open channel: %v\n", err)
+		return
+	}
+	defer ch.Close()
+	for _, prefix := range routing.PlayerQueuePrefixes {
+		queue := routing.Key(gameID, prefix, username)
+		_, err := ch.QueueDelete(queue, false, false, false)
+		if err != nil {
+			log.Printf("Failed to delete queue %v: %v\n", queue, err)
+			return
+		}
+	}
+}
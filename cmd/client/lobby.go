@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// lobbyClient talks to the server's lobby over request/reply.
+type lobbyClient struct {
+	conn     *amqp.Connection
+	username string
+	// starts holds the match starts of every game we listen to. Each game
+	// is only subscribed to once, since a second consumer on our queue
+	// would take turns with the first for its messages
+	starts map[string]chan gamelogic.MatchStart
+}
+
+func newLobbyClient(conn *amqp.Connection, username string) *lobbyClient {
+	return &lobbyClient{
+		conn:     conn,
+		username: username,
+		starts:   map[string]chan gamelogic.MatchStart{},
+	}
+}
+
+func (c *lobbyClient) Send(req gamelogic.LobbyRequest) (gamelogic.LobbyResponse, error) {
+	return pubsub.RequestJSON[gamelogic.LobbyRequest, gamelogic.LobbyResponse](
+		c.conn,
+		routing.ExchangePerilDirect,
+		routing.LobbyKey,
+		req,
+	)
+}
+
+func (c *lobbyClient) AwaitStart(gameID string) (<-chan gamelogic.MatchStart, error) {
+	if starts, ok := c.starts[gameID]; ok {
+		return starts, nil
+	}
+	starts := make(chan gamelogic.MatchStart, 1)
+	err := pubsub.SubscribeJSON(
+		c.conn,
+		routing.ExchangePerilDirect,
+		routing.Key(gameID, routing.MatchStartKey, c.username),
+		routing.Key(gameID, routing.MatchStartKey),
+		routing.Transient,
+		func(start gamelogic.MatchStart) routing.AckType {
+			select {
+			case starts <- start:
+			default:
+			}
+			return routing.Ack
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	c.starts[gameID] = starts
+	return starts, nil
+}
+
+// registryClient claims and releases our username with the server. It keeps
+// the key we sign with and caches the public keys of the server and the
+// other players for verifying their messages.
+type registryClient struct {
+	conn *amqp.Connection
+	// keyPath is where our key is kept, KeyPath of our username if empty
+	keyPath   string
+	key       ed25519.PrivateKey
+	serverKey ed25519.PublicKey
+	keys      map[string]ed25519.PublicKey
+	mu        *sync.Mutex
+}
+
+func newRegistryClient(conn *amqp.Connection, keyPath string) *registryClient {
+	return &registryClient{
+		conn:    conn,
+		keyPath: keyPath,
+		keys:    map[string]ed25519.PublicKey{},
+		mu:      &sync.Mutex{},
+	}
+}
+
+// trustServer loads the server's public key from path. If there is none
+// yet, the key is fetched from the registry and saved there, so every later
+// run can tell the real server from anyone else answering its queues.
+func (c *registryClient) trustServer(path string) error {
+	key, err := gamelogic.LoadPublicKey(path)
+	if errors.Is(err, os.ErrNotExist) {
+		var resp gamelogic.RegistryResponse
+		resp, err = c.send(gamelogic.RegistryRequest{
+			Action:   gamelogic.RegistryActionKey,
+			Username: gamelogic.ServerName,
+		})
+		if err != nil {
+			return err
+		}
+		key = resp.PublicKey
+		if len(key) != ed25519.PublicKeySize {
+			return errors.New("the server sent a bad key")
+		}
+		err = gamelogic.SavePublicKey(path, key)
+		if err != nil {
+			return err
+		}
+		log.Printf("Trusting server key %x on first use, saved to %v.\n", []byte(key), path)
+	}
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serverKey = key
+	return nil
+}
+
+// Claim claims username with our key for it, which is made the first time
+// and kept, so we can claim it again after a crash or a server restart.
+func (c *registryClient) Claim(username string) error {
+	path := c.keyPath
+	if path == "" {
+		path = gamelogic.KeyPath(username)
+	}
+	key, err := gamelogic.LoadKey(path)
+	if err != nil {
+		return fmt.Errorf("failed to load key: %v", err)
+	}
+	public := key.Public().(ed25519.PublicKey)
+	_, err = c.send(gamelogic.RegistryRequest{
+		Action:    gamelogic.RegistryActionClaim,
+		Username:  username,
+		PublicKey: public,
+		Proof:     ed25519.Sign(key, gamelogic.ClaimMessage(username, public)),
+	})
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.key = key
+	return nil
+}
+
+func (c *registryClient) Release(username string) error {
+	_, err := c.send(gamelogic.RegistryRequest{
+		Action:   gamelogic.RegistryActionRelease,
+		Username: username,
+		Proof:    ed25519.Sign(c.key, gamelogic.ReleaseMessage(username)),
+	})
+	return err
+}
+
+// PublicKey looks up the key a player signs with, asking the server the
+// first time we hear from them.
+func (c *registryClient) PublicKey(username string) (ed25519.PublicKey, bool) {
+	c.mu.Lock()
+	if username == gamelogic.ServerName {
+		defer c.mu.Unlock()
+		return c.serverKey, c.serverKey != nil
+	}
+	key, ok := c.keys[username]
+	c.mu.Unlock()
+	if ok {
+		return key, true
+	}
+
+	resp, err := c.send(gamelogic.RegistryRequest{
+		Action:   gamelogic.RegistryActionKey,
+		Username: username,
+	})
+	if err != nil {
+		log.Printf("Failed to look up key for %v: %v\n", username, err)
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys[username] = resp.PublicKey
+	return resp.PublicKey, true
+}
+
+// forget drops a player's cached key. A player that went offline may come
+// back under a new key if someone claimed their name in between.
+func (c *registryClient) forget(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.keys, username)
+}
+
+func (c *registryClient) send(req gamelogic.RegistryRequest) (gamelogic.RegistryResponse, error) {
+	resp, err := pubsub.RequestJSON[gamelogic.RegistryRequest, gamelogic.RegistryResponse](
+		c.conn,
+		routing.ExchangePerilDirect,
+		routing.RegistryKey,
+		req,
+	)
+	if err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -14,39 +17,57 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// onExit holds the work to do before the client stops, however it stops.
+var onExit []func()
+
 func cleanup() {
+	for _, f := range onExit {
+		f()
+	}
 	log.Print("Stopping Peril client...")
 }
 
 func handlerPause(gs *gamelogic.GameState) func(routing.PlayingState) routing.AckType {
 	return func(state routing.PlayingState) routing.AckType {
+		if gs.HandlePause(state) {
+			fmt.Print("> ")
+		}
+		return routing.Ack
+	}
+}
+
+func handlerTick(gs *gamelogic.GameState) func(routing.EconomyTick) routing.AckType {
+	return func(tick routing.EconomyTick) routing.AckType {
+		report, ok := gs.HandleTick(tick)
+		if ok {
+			report.Print()
+			fmt.Print("> ")
+		}
+		return routing.Ack
+	}
+}
+
+func handlerOwnership(gs *gamelogic.GameState) func(gamelogic.OwnershipChange) routing.AckType {
+	return func(change gamelogic.OwnershipChange) routing.AckType {
 		defer fmt.Print("> ")
-		gs.HandlePause(state)
+		gs.HandleOwnership(change)
+		return routing.Ack
+	}
+}
+
+func handlerGameOver(gs *gamelogic.GameState) func(gamelogic.GameOver) routing.AckType {
+	return func(over gamelogic.GameOver) routing.AckType {
+		defer fmt.Print("> ")
+		gs.HandleGameOver(over)
 		return routing.Ack
 	}
 }
 
-func handlerMove(gs *gamelogic.GameState, warCh *amqp.Channel) func(gamelogic.ArmyMove) routing.AckType {
+func handlerMove(gs *gamelogic.GameState) func(gamelogic.ArmyMove) routing.AckType {
 	return func(move gamelogic.ArmyMove) routing.AckType {
 		defer fmt.Print("> ")
-		outcome := gs.HandleMove(move)
-		switch outcome {
-		case gamelogic.MoveOutComeSafe:
-			return routing.Ack
-		case gamelogic.MoveOutcomeMakeWar:
-			// Publish message
-			err := pubsub.PublishJSON(
-				warCh,
-				routing.ExchangePerilTopic,
-				fmt.Sprintf("%v.%v", routing.WarRecognitionsPrefix, gs.Player.Username),
-				gamelogic.RecognitionOfWar{
-					Attacker: move.Player,
-					Defender: gs.GetPlayerSnap(),
-				},
-			)
-			if err != nil {
-				return routing.NackRequeue
-			}
+		switch gs.HandleMove(move) {
+		case gamelogic.MoveOutComeSafe, gamelogic.MoveOutcomeMakeWar:
 			return routing.Ack
 		case gamelogic.MoveOutcomeSamePlayer:
 			return routing.NackDiscard
@@ -56,33 +77,34 @@ func handlerMove(gs *gamelogic.GameState, warCh *amqp.Channel) func(gamelogic.Ar
 	}
 }
 
-func handlerWar(gs *gamelogic.GameState, glCh *amqp.Channel) func(gamelogic.RecognitionOfWar) routing.AckType {
-	return func(rw gamelogic.RecognitionOfWar) routing.AckType {
+func handlerBattle(gs *gamelogic.GameState, gameID string, glCh *amqp.Channel) func(gamelogic.Battle) routing.AckType {
+	return func(battle gamelogic.Battle) routing.AckType {
 		defer fmt.Print("> ")
 
-		outcome, winner, loser := gs.HandleWar(rw)
+		outcome, report := gs.HandleBattle(battle)
 		gl := routing.GameLog{
+			GameID:      gameID,
+			Event:       routing.LogEventBattle,
 			CurrentTime: time.Now(),
 			Username:    gs.GetUsername(),
 		}
 
 		switch outcome {
 		case gamelogic.WarOutcomeNotInvolved:
-			return routing.NackRequeue
+			return routing.Ack
 		case gamelogic.WarOutcomeNoUnits:
 			return routing.NackDiscard
-		case gamelogic.WarOutcomeOpponentWon, gamelogic.WarOutcomeYouWon:
-			gl.Message = fmt.Sprintf("%v won a war against %v", winner, loser)
-			err := publishGameLog(glCh, gl)
-			if err != nil {
-				return routing.NackRequeue
+		case gamelogic.WarOutcomeOpponentWon, gamelogic.WarOutcomeYouWon, gamelogic.WarOutcomeDraw:
+			// Only the aggressor logs the battle so it is recorded once
+			if battle.Aggressor != gs.GetUsername() {
+				return routing.Ack
 			}
-			return routing.Ack
-		case gamelogic.WarOutcomeDraw:
-			gl.Message = fmt.Sprintf("A war between %v and %v, resulted in a draw", winner, loser)
+			// The battle has been fought, so it is not requeued when its log
+			// can not be published; a redelivery would not fight it again
+			gl.Message = report.Summary()
 			err := publishGameLog(glCh, gl)
 			if err != nil {
-				return routing.NackRequeue
+				log.Printf("Failed to publish battle log: %v\n", err)
 			}
 			return routing.Ack
 		default:
@@ -93,7 +115,10 @@ func handlerWar(gs *gamelogic.GameState, glCh *amqp.Channel) func(gamelogic.Reco
 }
 
 func publishGameLog(ch *amqp.Channel, gl routing.GameLog) error {
-	routingKey := fmt.Sprintf("%v.%v", routing.GameLogSlug, gl.Username)
+	if gl.ID == "" {
+		gl.ID = gamelogic.NewLogID()
+	}
+	routingKey := routing.Key(gl.GameID, routing.GameLogSlug, gl.Username)
 	err := pubsub.PublishGob(
 		ch,
 		string(routing.ExchangePerilTopic),
@@ -104,6 +129,32 @@ func publishGameLog(ch *amqp.Channel, gl routing.GameLog) error {
 }
 
 func main() {
+	rulesPath := flag.String("rules", "", "path to a JSON rules file the server's ruleset must match")
+	gameID := flag.String("game", routing.DefaultGameID, "ID of the game to join, skipping the lobby")
+	savePath := flag.String("save", "", "path to the save file (defaults to peril-<game>-<username>.save.json)")
+	keyPath := flag.String("key", "", "path to the key we sign with (defaults to peril-<username>.key, created if missing)")
+	serverKeyPath := flag.String("server-key", "keys/server.key.pub", "path to the server's public key (fetched and saved on first use if missing)")
+	flag.Parse()
+
+	gameFlagSet := false
+	flag.Visit(func(f *flag.Flag) {
+		gameFlagSet = gameFlagSet || f.Name == "game"
+	})
+
+	err := routing.ValidateGameID(*gameID)
+	if err != nil {
+		log.Fatalf("Invalid game: %v\n", err)
+	}
+
+	var localRules *gamelogic.Rules
+	if *rulesPath != "" {
+		rules, err := gamelogic.LoadRules(*rulesPath)
+		if err != nil {
+			log.Fatalf("Failed to load rules: %v\n", err)
+		}
+		localRules = &rules
+	}
+
 	// Capture ctrl + ctrlC for cleanup
 	ctrlC := make(chan os.Signal, 1.)
 	signal.Notify(ctrlC, os.Interrupt, syscall.SIGTERM)
@@ -128,21 +179,125 @@ func main() {
 	defer rabbitMQConnection.Close()
 
 	/**************************************************************************
-	GameState
+	Lobby
 	**************************************************************************/
-	username, err := gamelogic.ClientWelcome()
+	// Only accept messages, replies included, signed by the server or the
+	// player they are from
+	registry := newRegistryClient(rabbitMQConnection, *keyPath)
+	err = registry.trustServer(*serverKeyPath)
+	if err != nil {
+		log.Fatalf("Failed to get the server's key: %v\n", err)
+	}
+	pubsub.UseVerifier(pubsub.NewVerifier(gamelogic.ServerName, registry.PublicKey))
+
+	username, err := gamelogic.ClientWelcome(registry)
 	if err != nil {
 		log.Fatalf("Failed to get username: %v\n", err)
 	}
 
+	// Sign everything we publish with our key
+	pubsub.UseSigner(&pubsub.Signer{Name: username, Key: registry.key})
+	onExit = append(onExit, func() {
+		err := registry.Release(username)
+		if err != nil {
+			log.Printf("Failed to release username: %v\n", err)
+		}
+	})
+
+	// Players who name a game on the command line skip the lobby
+	var start *gamelogic.MatchStart
+	if !gameFlagSet {
+		ms, err := gamelogic.ClientLobby(username, newLobbyClient(rabbitMQConnection, username))
+		if err != nil {
+			log.Fatalf("Failed to join a game: %v\n", err)
+		}
+		start = &ms
+		*gameID = ms.GameID
+	}
+
+	/**************************************************************************
+	Ruleset
+	**************************************************************************/
+	announcement, ok, err := pubsub.PeekJSON[gamelogic.RulesetAnnouncement](
+		rabbitMQConnection,
+		routing.ExchangePerilDirect,
+		routing.Key(*gameID, routing.RulesetKey),
+		routing.Key(*gameID, routing.RulesetKey),
+		routing.Retained,
+	)
+	if err != nil {
+		log.Fatalf("Failed to fetch ruleset: %v\n", err)
+	}
+	if !ok {
+		log.Fatalf("No ruleset has been published for game %v, is a Peril server hosting it?\n", *gameID)
+	}
+	rules, err := gamelogic.AdoptRules(announcement, localRules)
+	if err != nil {
+		log.Fatalf("Refusing to join: %v\n", err)
+	}
+	log.Printf("Using ruleset %q.\n", rules.Name)
+
+	/**************************************************************************
+	GameState
+	**************************************************************************/
 	// Create the game state
-	gs := gamelogic.NewGameState(username)
+	gs := gamelogic.NewGameState(username, rules)
+	if start != nil {
+		gs.HandleMatchStart(*start)
+	}
+
+	/**************************************************************************
+	Save
+	**************************************************************************/
+	if *savePath == "" {
+		*savePath = gamelogic.SavePath(*gameID, username)
+	}
+	save, ok, err := gamelogic.LoadSave(*savePath)
+	if err != nil {
+		log.Fatalf("Failed to load save: %v\n", err)
+	}
+	// The server knows what happened to our units while we were away, and
+	// how new a pause state has to be
+	var session *gamelogic.SessionResponse
+	resp, err := pubsub.RequestJSON[gamelogic.SessionRequest, gamelogic.SessionResponse](
+		rabbitMQConnection,
+		routing.ExchangePerilDirect,
+		routing.Key(*gameID, routing.SessionKey),
+		gamelogic.SessionRequest{
+			Username: username,
+			Proof:    ed25519.Sign(registry.key, gamelogic.SessionMessage(*gameID, username)),
+		},
+	)
+	if err == nil && resp.Error != "" {
+		err = errors.New(resp.Error)
+	}
+	if err != nil {
+		log.Printf("Joining without the server's view: %v\n", err)
+	} else {
+		session = &resp
+	}
+	if ok {
+		err = gs.Resume(save, session)
+		if err != nil {
+			log.Fatalf("Failed to resume: %v\n", err)
+		}
+	}
+	if session != nil {
+		gs.HandlePause(session.Pause)
+	}
+	gs.AutoSave(*gameID, *savePath)
+	onExit = append(onExit, func() {
+		err := gs.Save()
+		if err != nil {
+			log.Printf("Failed to save game: %v\n", err)
+		}
+	})
 
 	/**************************************************************************
 	GameLogs
 	**************************************************************************/
-	glQueueName := routing.GameLogSlug
-	glRoutingKey := routing.GameLogSlug
+	glQueueName := routing.Key(*gameID, routing.GameLogSlug)
+	glRoutingKey := routing.Pattern(*gameID, routing.GameLogSlug)
 	glQueueType := routing.Durable
 
 	glChannel, _, err := pubsub.DeclareAndBind(
@@ -159,8 +314,8 @@ func main() {
 	/**************************************************************************
 	RabbitMQ Pause
 	**************************************************************************/
-	pauseQueueName := fmt.Sprintf("%v.%v", routing.PauseKey, username)
-	pauseRoutingKey := routing.PauseKey
+	pauseQueueName := routing.Key(*gameID, routing.PauseKey, username)
+	pauseRoutingKey := routing.Key(*gameID, routing.PauseKey)
 	pauseQueueType := routing.Transient
 
 	// Create transient exchange per user/clien
@@ -189,42 +344,111 @@ func main() {
 	}
 
 	/**************************************************************************
-	RabbitMQ War
+	Pause State
 	**************************************************************************/
-	warQueueName := routing.WarRecognitionsPrefix                       // durable shared queue
-	warRoutingKey := fmt.Sprintf("%v.*", routing.WarRecognitionsPrefix) // match all usernames
-	warQueueType := routing.Durable
+	// The game may have been paused before we joined. It is read once our
+	// pause queue is bound, so a state published in between is not missed,
+	// and whichever copy of it comes second is ignored by its version. A
+	// state older than the one the server told us about was put back in the
+	// queue and is ignored too
+	state, ok, err := pubsub.PeekJSON[routing.PlayingState](
+		rabbitMQConnection,
+		routing.ExchangePerilDirect,
+		routing.Key(*gameID, routing.PauseStateKey),
+		routing.Key(*gameID, routing.PauseKey),
+		routing.Retained,
+	)
+	if err != nil {
+		log.Fatalf("Failed to read pause state: %v\n", err)
+	}
+	if ok {
+		gs.HandlePause(state)
+	}
+
+	/**************************************************************************
+	RabbitMQ Economy
+	**************************************************************************/
+	tickQueueName := routing.Key(*gameID, routing.EconomyTickKey, username)
+
+	err = pubsub.SubscribeJSON(
+		rabbitMQConnection,
+		routing.ExchangePerilDirect,
+		tickQueueName,
+		routing.Key(*gameID, routing.EconomyTickKey),
+		routing.Transient,
+		handlerTick(gs),
+	)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to economy ticks: %v\n", err)
+	}
+
+	/**************************************************************************
+	RabbitMQ Game Over
+	**************************************************************************/
+	err = pubsub.SubscribeJSON(
+		rabbitMQConnection,
+		routing.ExchangePerilDirect,
+		routing.Key(*gameID, routing.GameOverKey, username),
+		routing.Key(*gameID, routing.GameOverKey),
+		routing.Transient,
+		handlerGameOver(gs),
+	)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to game over: %v\n", err)
+	}
+
+	/**************************************************************************
+	RabbitMQ Ownership
+	**************************************************************************/
+	err = pubsub.SubscribeJSON(
+		rabbitMQConnection,
+		routing.ExchangePerilTopic,
+		routing.Key(*gameID, routing.OwnershipPrefix, username),
+		routing.Pattern(*gameID, routing.OwnershipPrefix),
+		routing.Transient,
+		handlerOwnership(gs),
+	)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to ownership changes: %v\n", err)
+	}
 
-	// Create durable shared exchange
-	warChannel, _, err := pubsub.DeclareAndBind(
+	/**************************************************************************
+	RabbitMQ Battles
+	**************************************************************************/
+	battlesQueueName := routing.Key(*gameID, routing.BattlesPrefix, username) // every client sees every battle
+	battlesRoutingKey := routing.Pattern(*gameID, routing.BattlesPrefix)      // match all usernames
+	battlesQueueType := routing.Transient
+
+	// Create transient exchange per user/client
+	_, _, err = pubsub.DeclareAndBind(
 		rabbitMQConnection,
 		routing.ExchangePerilTopic,
-		warQueueName,
-		warRoutingKey,
-		warQueueType,
+		battlesQueueName,
+		battlesRoutingKey,
+		battlesQueueType,
 	)
 	if err != nil {
-		log.Fatalf("Failed to declare and bind war queue: %v", err)
+		log.Fatalf("Failed to declare and bind battles queue: %v", err)
 	}
 
-	// Subscribe to war
+	// Subscribe to battles, which only the server declares
 	err = pubsub.SubscribeJSON(
 		rabbitMQConnection,
 		routing.ExchangePerilTopic,
-		warQueueName,
-		warRoutingKey,
-		warQueueType,
-		handlerWar(gs, glChannel),
+		battlesQueueName,
+		battlesRoutingKey,
+		battlesQueueType,
+		handlerBattle(gs, *gameID, glChannel),
 	)
 	if err != nil {
-		log.Fatalf("Failed to subscribe to war JSON: %v", err)
+		log.Fatalf("Failed to subscribe to battles JSON: %v", err)
 	}
 
 	/**************************************************************************
 	RabbitMQ Moves
 	**************************************************************************/
-	movesQueueName := fmt.Sprintf("%v.%v", routing.ArmyMovesPrefix, username)
-	movesRoutingKey := fmt.Sprintf("%v.*", routing.ArmyMovesPrefix)
+	movesQueueName := routing.Key(*gameID, routing.ArmyMovesPrefix, username)
+	movesRoutingKey := routing.Pattern(*gameID, routing.ArmyMovesPrefix)
 	movesQueueType := routing.Transient
 
 	// Create transient exchange per user/client
@@ -240,18 +464,78 @@ func main() {
 	}
 
 	// Subscribe to all moves
-	err = pubsub.SubscribeJSON(
+	err = pubsub.SubscribeJSONFrom(
 		rabbitMQConnection,
 		routing.ExchangePerilTopic,
 		movesQueueName,
 		movesRoutingKey,
 		movesQueueType,
-		handlerMove(gs, warChannel),
+		func(move gamelogic.ArmyMove) string { return move.Player.Username },
+		handlerMove(gs),
 	)
 	if err != nil {
 		log.Fatalf("Failed to subscribe moves JSON: %v", err)
 	}
 
+	/**************************************************************************
+	RabbitMQ Admin
+	**************************************************************************/
+	err = pubsub.SubscribeJSON(
+		rabbitMQConnection,
+		routing.ExchangePerilDirect,
+		routing.Key(*gameID, routing.AnnouncementKey, username),
+		routing.Key(*gameID, routing.AnnouncementKey),
+		routing.Transient,
+		handlerAnnouncement(gs),
+	)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to announcements: %v\n", err)
+	}
+
+	err = pubsub.SubscribeJSON(
+		rabbitMQConnection,
+		routing.ExchangePerilDirect,
+		routing.Key(*gameID, routing.KickKey, username),
+		routing.Key(*gameID, routing.KickKey, username),
+		routing.Transient,
+		handlerKick(gs, rabbitMQConnection, *gameID),
+	)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to kicks: %v\n", err)
+	}
+
+	/**************************************************************************
+	RabbitMQ Presence
+	**************************************************************************/
+	err = pubsub.SubscribeJSON(
+		rabbitMQConnection,
+		routing.ExchangePerilDirect,
+		routing.Key(*gameID, routing.PresenceChangesKey, username),
+		routing.Key(*gameID, routing.PresenceChangesKey),
+		routing.Transient,
+		handlerPresence(gs, registry),
+	)
+	if err != nil {
+		log.Fatalf("Failed to subscribe to presence changes: %v\n", err)
+	}
+
+	presenceChannel, err := rabbitMQConnection.Channel()
+	if err != nil {
+		log.Fatalf("Failed to open presence channel: %v\n", err)
+	}
+	publicKey := registry.key.Public().(ed25519.PublicKey)
+	err = publishPresence(presenceChannel, *gameID, username, publicKey, gamelogic.PresenceJoin)
+	if err != nil {
+		log.Fatalf("Failed to announce ourselves: %v\n", err)
+	}
+	onExit = append(onExit, func() {
+		err := publishPresence(presenceChannel, *gameID, username, publicKey, gamelogic.PresenceLeave)
+		if err != nil {
+			log.Printf("Failed to announce we are leaving: %v\n", err)
+		}
+	})
+	go runHeartbeats(presenceChannel, *gameID, username, publicKey)
+
 	/**************************************************************************
 	REPL
 	**************************************************************************/
@@ -263,9 +547,22 @@ func main() {
 
 		switch words[0] {
 		case "spawn":
-			err = gs.CommandSpawn(words)
+			spawn, err := gs.CommandSpawn(words)
 			if err != nil {
 				log.Printf("Failed to spawn unit: %v\n", err)
+				continue
+			}
+
+			err = pubsub.PublishJSON(
+				movesChannel,
+				routing.ExchangePerilTopic,
+				routing.Key(*gameID, routing.ArmySpawnsPrefix, username),
+				spawn,
+			)
+			if err != nil {
+				log.Printf("Failed to publish spawn JSON: %v\n", err)
+				gs.CancelSpawn(spawn)
+				fmt.Println("The spawn was taken back and refunded.")
 			}
 		case "move":
 			move, err := gs.CommandMove(words)
@@ -283,11 +580,27 @@ func main() {
 			)
 			if err != nil {
 				log.Printf("Failed to publish move JSON: %v\n", err)
+				gs.CancelMove(move)
+				fmt.Println("The move was taken back and refunded.")
 			} else {
 				log.Printf("Move unit %v to %v successful\n", words[2], words[1])
 			}
+		case "ally":
+			err = gs.CommandAlly(words)
+			if err != nil {
+				log.Printf("Failed to ally: %v\n", err)
+			}
+		case "unally":
+			err = gs.CommandUnally(words)
+			if err != nil {
+				log.Printf("Failed to break alliance: %v\n", err)
+			}
 		case "status":
 			gs.CommandStatus()
+		case "world":
+			gs.CommandWorld()
+		case "players":
+			gs.CommandPlayers()
 		case "help":
 			gamelogic.PrintClientHelp()
 		case "spam":
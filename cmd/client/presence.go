@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func handlerPresence(gs *gamelogic.GameState, registry *registryClient) func(gamelogic.PresenceChange) routing.AckType {
+	return func(change gamelogic.PresenceChange) routing.AckType {
+		if !change.Online {
+			registry.forget(change.Username)
+		}
+		if gs.HandlePresence(change) {
+			fmt.Print("> ")
+		}
+		return routing.Ack
+	}
+}
+
+func publishPresence(ch *amqp.Channel, gameID, username string, key ed25519.PublicKey, kind gamelogic.PresenceKind) error {
+	return pubsub.PublishJSON(
+		ch,
+		routing.ExchangePerilTopic,
+		routing.Key(gameID, routing.PresencePrefix, username),
+		gamelogic.PresenceEvent{
+			Username:  username,
+			Kind:      kind,
+			SentAt:    time.Now(),
+			PublicKey: key,
+		},
+	)
+}
+
+// runHeartbeats tells the server we are still here every heartbeat interval.
+func runHeartbeats(ch *amqp.Channel, gameID, username string, key ed25519.PublicKey) {
+	ticker := time.NewTicker(gamelogic.HeartbeatInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := publishPresence(ch, gameID, username, key, gamelogic.PresenceHeartbeat)
+		if err != nil {
+			log.Printf("Failed to publish heartbeat: %v\n", err)
+		}
+	}
+}
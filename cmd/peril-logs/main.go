@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+const followInterval = 500 * time.Millisecond
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		runVerify(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplay(os.Args[2:])
+		return
+	}
+
+	logDefaults := gamelogic.DefaultLogWriterConfig()
+	logFile := flag.String("file", logDefaults.Path, "path to the server's game log; its rotated logs are read too")
+	users := flag.String("user", "", "only show logs from these users, comma separated")
+	events := flag.String("event", "", "only show these events, comma separated (battle, game_over, message, checkpoint)")
+	gameID := flag.String("game", "", "only show logs from this game")
+	since := flag.String("since", "", "only show logs from this time on: a date, a date and time, a duration ago, today or yesterday")
+	until := flag.String("until", "", "only show logs before this time, in the same forms as -since")
+	text := flag.String("text", "", "only show logs whose message contains this text, ignoring case")
+	flagged := flag.Bool("flagged", false, "only show logs with an implausible timestamp")
+	follow := flag.Bool("f", false, "keep printing logs as they are written")
+	format := flag.String("format", "table", "output format: table, json or csv")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: peril-logs [flags] [files...]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       peril-logs verify [flags] [files...]\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "       peril-logs replay [flags] [history file]\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Reads the server's game logs, or the given files, in the order the server received them.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	now := time.Now()
+	filter := gamelogic.LogFilter{
+		Users:   splitList(*users),
+		Events:  splitList(*events),
+		GameID:  *gameID,
+		Text:    *text,
+		Flagged: *flagged,
+	}
+	var err error
+	filter.Since, err = parseTime(*since, now)
+	if err != nil {
+		log.Fatalf("Failed to parse -since: %v\n", err)
+	}
+	filter.Until, err = parseTime(*until, now)
+	if err != nil {
+		log.Fatalf("Failed to parse -until: %v\n", err)
+	}
+
+	out, err := newOutput(*format, os.Stdout)
+	if err != nil {
+		log.Fatalf("Failed to set up output: %v\n", err)
+	}
+
+	files := flag.Args()
+	live := ""
+	if len(files) == 0 {
+		files, err = gamelogic.LogFiles(*logFile)
+		if err != nil {
+			log.Fatalf("Failed to find game logs: %v\n", err)
+		}
+		live = *logFile
+	} else {
+		live = files[len(files)-1]
+	}
+
+	// Logs from several servers are merged in the order the servers
+	// received them
+	records := []gamelogic.LogRecord{}
+	var offset int64
+	for _, file := range files {
+		read, err := gamelogic.ReadLogFile(file, 0, func(r gamelogic.LogRecord) {
+			if filter.Match(r) {
+				records = append(records, r)
+			}
+		})
+		if err != nil {
+			log.Fatalf("Failed to read game log: %v\n", err)
+		}
+		if file == live {
+			offset = read
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].ServerTime().Before(records[j].ServerTime())
+	})
+	for _, r := range records {
+		out.write(r)
+	}
+	out.flush()
+
+	show := func(r gamelogic.LogRecord) {
+		if filter.Match(r) {
+			out.write(r)
+		}
+	}
+
+	if *follow {
+		followLog(live, offset, show, out)
+	}
+}
+
+// followLog prints what is appended to the live log, starting at offset. A
+// file that shrinks or is replaced has been rotated, so it is read again
+// from the start.
+func followLog(path string, offset int64, show func(gamelogic.LogRecord), out output) {
+	info, _ := os.Stat(path)
+	for {
+		time.Sleep(followInterval)
+		current, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Fatalf("Failed to follow game log: %v\n", err)
+		}
+		if info == nil || !os.SameFile(info, current) || current.Size() < offset {
+			offset = 0
+		}
+		info = current
+		if current.Size() == offset {
+			continue
+		}
+		offset, err = gamelogic.ReadLogFile(path, offset, show)
+		if err != nil {
+			log.Fatalf("Failed to follow game log: %v\n", err)
+		}
+		out.flush()
+	}
+}
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseTime reads a time given on the command line, in local time unless
+// it has a zone. A time of day is today's. An empty string is the zero
+// time.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch s {
+	case "":
+		return time.Time{}, nil
+	case "now":
+		return now, nil
+	case "today":
+		return midnight, nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{time.DateTime, "2006-01-02 15:04", time.DateOnly} {
+		t, err := time.ParseInLocation(layout, s, now.Location())
+		if err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range []string{time.TimeOnly, "15:04"} {
+		t, err := time.ParseInLocation(layout, s, now.Location())
+		if err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location()), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q, use a date, a date and time, a time of day, a duration such as 2h, today or yesterday", s)
+}
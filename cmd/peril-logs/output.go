@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+// output prints game log records. flush is called after every read, so a
+// followed log shows up as it is written.
+type output interface {
+	write(gamelogic.LogRecord)
+	flush()
+}
+
+func newOutput(format string, w io.Writer) (output, error) {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tEVENT\tGAME\tUSER\tMESSAGE\tFLAG")
+		return &tableOutput{tw: tw}, nil
+	case "json":
+		return &jsonOutput{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"received_at", "time", "clock_skew", "flag", "event", "game", "message_id", "server", "user", "message"})
+		return &csvOutput{cw: cw}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, use table, json or csv", format)
+	}
+}
+
+type tableOutput struct {
+	tw *tabwriter.Writer
+}
+
+func (o *tableOutput) write(r gamelogic.LogRecord) {
+	fmt.Fprintf(o.tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.ServerTime().Local().Format(time.DateTime), r.Event, r.GameID, r.Username, r.Message, r.Flag)
+}
+
+func (o *tableOutput) flush() {
+	o.tw.Flush()
+}
+
+type jsonOutput struct {
+	enc *json.Encoder
+}
+
+func (o *jsonOutput) write(r gamelogic.LogRecord) {
+	err := o.enc.Encode(r)
+	if err != nil {
+		log.Fatalf("Failed to write record: %v\n", err)
+	}
+}
+
+func (o *jsonOutput) flush() {}
+
+type csvOutput struct {
+	cw *csv.Writer
+}
+
+func (o *csvOutput) write(r gamelogic.LogRecord) {
+	o.cw.Write([]string{
+		r.ServerTime().Format(time.RFC3339Nano),
+		r.Time.Format(time.RFC3339Nano),
+		r.ClockSkew.String(),
+		r.Flag,
+		r.Event,
+		r.GameID,
+		r.MessageID,
+		r.Server,
+		r.Username,
+		r.Message,
+	})
+}
+
+func (o *csvOutput) flush() {
+	o.cw.Flush()
+	err := o.cw.Error()
+	if err != nil {
+		log.Fatalf("Failed to write records: %v\n", err)
+	}
+}
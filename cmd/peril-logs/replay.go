@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// runReplay rebuilds a game's world from its history, as it was at a point
+// in time or after a given event, and prints it.
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	dir := fs.String("dir", gamelogic.DefaultEventsDir, "directory the server keeps game histories in")
+	gameID := fs.String("game", routing.DefaultGameID, "game to replay")
+	at := fs.String("at", "", "replay up to this time, in the same forms as -since (the end if empty)")
+	seq := fs.Int64("seq", 0, "replay up to and including this event (the end if 0)")
+	list := fs.Bool("list", false, "print every event replayed")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: peril-logs replay [flags] [history file]\n\n")
+		fmt.Fprintf(fs.Output(), "Rebuilds a game's world from its history. Archived games are replayed by passing their file.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	until, err := parseTime(*at, time.Now())
+	if err != nil {
+		log.Fatalf("Failed to parse -at: %v\n", err)
+	}
+
+	path := gamelogic.EventLogPath(*dir, *gameID)
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	events, err := gamelogic.ReadEvents(path)
+	if err != nil {
+		log.Fatalf("Failed to read game history: %v\n", err)
+	}
+
+	var last gamelogic.WorldEvent
+	world, err := gamelogic.ReplayWorld(events, func(ev gamelogic.WorldEvent) bool {
+		if *seq > 0 && ev.Seq > *seq || !until.IsZero() && ev.At.After(until) {
+			return true
+		}
+		if *list {
+			fmt.Println(ev)
+		}
+		last = ev
+		return false
+	})
+	if err != nil {
+		log.Fatalf("Failed to replay game history: %v\n", err)
+	}
+
+	if last.Seq == 0 {
+		log.Fatalf("Failed to replay game history: the game was created after that\n")
+	}
+	if *list {
+		fmt.Println()
+	}
+	fmt.Printf("Game %s after event %d of %d, %s:\n", last.GameID, last.Seq, len(events), last.At.Local().Format(time.DateTime))
+	switch {
+	case world.IsOver():
+		fmt.Println("The game is over.")
+	case world.Pause.IsPaused:
+		fmt.Printf("The game is paused: %s\n", world.Pause.Reason)
+	}
+	gamelogic.PrintTerritories(world.Territories())
+	gamelogic.PrintStandings(world.Standings())
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+)
+
+// runVerify checks a game log's hash chain and checkpoints, and exits with
+// status 1 if it has been tampered with.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	logFile := fs.String("file", gamelogic.DefaultLogWriterConfig().Path, "path to the server's game log; its rotated logs are checked too")
+	pubPath := fs.String("pub", "", "path to the server's public key, such as keys/logs.key.pub (checkpoints from any key are accepted if empty)")
+	fs.Parse(args)
+
+	var trusted ed25519.PublicKey
+	if *pubPath != "" {
+		key, err := gamelogic.LoadPublicKey(*pubPath)
+		if err != nil {
+			log.Fatalf("Failed to load public key: %v\n", err)
+		}
+		trusted = key
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		var err error
+		files, err = gamelogic.LogFiles(*logFile)
+		if err != nil {
+			log.Fatalf("Failed to find game logs: %v\n", err)
+		}
+	}
+
+	v, err := gamelogic.VerifyLog(files, trusted)
+	if err != nil {
+		log.Fatalf("Failed to read game log: %v\n", err)
+	}
+
+	fmt.Printf("Checked %d record(s) in %d file(s).\n", v.Records, len(files))
+	if v.FirstSeq > 1 {
+		fmt.Printf("* The chain starts at entry %d; earlier entries were rotated away or removed.\n", v.FirstSeq)
+	}
+	if v.Unchained > 0 {
+		fmt.Printf("* %d record(s) are not chained, such as text lines.\n", v.Unchained)
+	}
+	fmt.Printf("* %d signed checkpoint(s), the last at entry %d.\n", v.Checkpoints, v.LastCheckpointSeq)
+	if trusted == nil {
+		for _, signer := range v.Signers {
+			fmt.Printf("* Signed by key %s; pass -pub to require the server's key.\n", signer)
+		}
+	}
+	if v.Uncovered() > 0 {
+		fmt.Printf("* %d entry(s) after the last checkpoint are chained but not signed yet.\n", v.Uncovered())
+	}
+
+	if v.OK() {
+		fmt.Println("The log is intact.")
+		return
+	}
+	fmt.Printf("The log has been tampered with, %d problem(s):\n", len(v.Problems))
+	for _, p := range v.Problems {
+		fmt.Printf("* %s\n", p)
+	}
+	os.Exit(1)
+}
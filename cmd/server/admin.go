@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// announce sends a message to every client in every game.
+func (s *server) announce(message string) {
+	a := gamelogic.Announcement{
+		Message: message,
+		SentAt:  time.Now(),
+	}
+	for _, g := range s.allGames() {
+		err := pubsub.PublishJSON(
+			g.ch,
+			routing.ExchangePerilDirect,
+			routing.Key(g.id, routing.AnnouncementKey),
+			a,
+		)
+		if err != nil {
+			log.Printf("Failed to publish announcement to game %v: %v\n", g.id, err)
+		}
+	}
+}
+
+// kick tells a player's client to disconnect, in whichever game they are
+// playing, and revokes their username and key. Their queues are deleted
+// too, so a client that ignores the kick stops hearing about the game, and
+// its messages are rejected until it claims the name again.
+func (s *server) kick(username, reason string, banned bool) {
+	k := gamelogic.Kick{
+		Username: username,
+		Reason:   reason,
+		Banned:   banned,
+	}
+	for _, g := range s.allGames() {
+		err := pubsub.PublishJSON(
+			g.ch,
+			routing.ExchangePerilDirect,
+			routing.Key(g.id, routing.KickKey, username),
+			k,
+		)
+		if err != nil {
+			log.Printf("Failed to publish kick to game %v: %v\n", g.id, err)
+		}
+		g.deletePlayerQueues(username)
+	}
+	err := s.registry.Revoke(username)
+	if err != nil {
+		log.Printf("Failed to save username registry: %v\n", err)
+	}
+}
+
+// deletePlayerQueues deletes the queues a player's client declared for
+// itself in the game, except the one the kick is waiting in. That one only
+// carries kicks and goes once the client disconnects.
+func (g *game) deletePlayerQueues(username string) {
+	ch, err := g.conn.Channel()
+	if err != nil {
+		log.Printf("Failed to open channel: %v\n", err)
+		return
+	}
+	defer ch.Close()
+	for _, prefix := range routing.PlayerQueuePrefixes {
+		if prefix == routing.KickKey {
+			continue
+		}
+		queue := routing.Key(g.id, prefix, username)
+		_, err := ch.QueueDelete(queue, false, false, false)
+		if err != nil {
+			log.Printf("Failed to delete queue %v: %v\n", queue, err)
+			return
+		}
+	}
+}
+
+// ban kicks a player and keeps them out: the registry refuses their name
+// and their messages no longer verify.
+func (s *server) ban(username, reason string) error {
+	err := s.bans.Ban(username, reason)
+	if err != nil {
+		return fmt.Errorf("failed to save ban list: %v", err)
+	}
+	s.kick(username, reason, true)
+	return nil
+}
+
+// publicKey is the key a message signer is checked against. Banned players
+// have none.
+func (s *server) publicKey(username string) (ed25519.PublicKey, bool) {
+	if s.bans.IsBanned(username) {
+		return nil, false
+	}
+	return s.registry.PublicKey(username)
+}
+
+func (s *server) allGames() []*game {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	games := []*game{}
+	for _, g := range s.games {
+		games = append(games, g)
+	}
+	return games
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// runEconomy publishes an economy tick for the game every tick interval while
+// it is not paused, until the game is over or closed. Ticks are numbered by
+// how many intervals have passed since the game was created, so they keep
+// going up over a server restart and clients do not ignore them as stale.
+// Each tick is recorded before it is published, so the server's treasuries
+// earn and pay exactly what the clients' do.
+func runEconomy(g *game) {
+	tick := 0
+	interval := g.rules.TickInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.done:
+			return
+		case now := <-ticker.C:
+			if g.world.IsOver() {
+				return
+			}
+			if g.paused.Load() {
+				continue
+			}
+			tick = max(tick+1, int(now.Sub(g.world.CreatedAt)/interval))
+			et := routing.EconomyTick{
+				Tick:        tick,
+				CurrentTime: now,
+			}
+			_, _, err := g.history.Record(g.world, gamelogic.WorldEvent{
+				At:   now,
+				Kind: gamelogic.EventTick,
+				Tick: &et,
+			})
+			if err != nil {
+				log.Printf("Failed to record economy tick: %v\n", err)
+				continue
+			}
+			err = pubsub.PublishJSON(
+				g.ch,
+				routing.ExchangePerilDirect,
+				routing.Key(g.id, routing.EconomyTickKey),
+				et,
+			)
+			if err != nil {
+				log.Printf("Failed to publish economy tick: %v\n", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// serverID names the queues this server instance consumes on its own.
+var serverID = fmt.Sprintf("server-%d", os.Getpid())
+
+// game is one match hosted by this server. Each game has its own connection
+// so closing it tears down all of its consumers at once.
+type game struct {
+	id    string
+	rules gamelogic.Rules
+	world *gamelogic.World
+	// history records every change to the world before it is applied
+	history *gamelogic.EventLog
+	roster  *gamelogic.Roster
+	// registry is shared by every game on the server
+	registry *gamelogic.Registry
+	logs     *gamelogic.LogWriter
+	// logSub consumes the game's logs, and pendingLogs counts those handed
+	// to the writer but not acked yet
+	logSub      *pubsub.Subscription
+	pendingLogs *sync.WaitGroup
+	clocks      *gamelogic.ClockSkews
+	paused      atomic.Bool
+	// pauseState is the last pause state published, guarded by pauseMu
+	pauseState routing.PlayingState
+	pauseMu    *sync.Mutex
+	schedule   *gamelogic.PauseSchedule
+	conn       *amqp.Connection
+	ch         *amqp.Channel
+	done       chan struct{}
+}
+
+// queues returns the name of every queue the game uses, its players'
+// included.
+func (g *game) queues() []string {
+	queues := []string{
+		routing.Key(g.id, routing.RulesetKey),
+		routing.Key(g.id, routing.PauseStateKey),
+		routing.Key(g.id, routing.GameLogSlug),
+		routing.Key(g.id, routing.SessionKey),
+	}
+	for _, prefix := range []string{
+		routing.ArmySpawnsPrefix,
+		routing.ArmyMovesPrefix,
+		routing.BattlesPrefix,
+		routing.PresencePrefix,
+	} {
+		queues = append(queues, routing.Key(g.id, prefix, serverID))
+	}
+	for _, p := range g.roster.Players() {
+		if !p.Online {
+			continue
+		}
+		for _, prefix := range routing.PlayerQueuePrefixes {
+			queues = append(queues, routing.Key(g.id, prefix, p.Username))
+		}
+	}
+	return queues
+}
+
+func startGame(s *server, id string) (*game, error) {
+	err := routing.ValidateGameID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	history, world, err := openHistory(s, id)
+	if err != nil {
+		return nil, err
+	}
+	rules := world.Rules
+
+	schedule, err := gamelogic.LoadPauseSchedule(gamelogic.PauseSchedulePath(s.eventsDir, id))
+	if err != nil {
+		history.Close()
+		return nil, fmt.Errorf("failed to load pause schedule: %v", err)
+	}
+
+	conn, err := amqp.Dial(s.url)
+	if err != nil {
+		history.Close()
+		return nil, fmt.Errorf("failed to connect to RabbitMQ server: %v", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		history.Close()
+		return nil, fmt.Errorf("failed to open channel: %v", err)
+	}
+
+	g := &game{
+		id:          id,
+		rules:       rules,
+		world:       world,
+		history:     history,
+		roster:      gamelogic.NewRoster(),
+		registry:    s.registry,
+		logs:        s.logs,
+		pendingLogs: &sync.WaitGroup{},
+		clocks:      s.clocks,
+		pauseMu:     &sync.Mutex{},
+		schedule:    schedule,
+		conn:        conn,
+		ch:          ch,
+		done:        make(chan struct{}),
+	}
+	err = g.setup()
+	if err != nil {
+		conn.Close()
+		history.Close()
+		return nil, err
+	}
+
+	go runEconomy(g)
+	go runVictoryClock(g)
+	go runPresenceMonitor(g)
+	go runPauseScheduler(g)
+	return g, nil
+}
+
+// openHistory opens a game's history and returns its world. A game left
+// behind by a restart or a crash is rebuilt from its history, rules
+// included, unless it had already ended. Then its history is archived and
+// it starts afresh, like a new game.
+func openHistory(s *server, id string) (*gamelogic.EventLog, *gamelogic.World, error) {
+	path := gamelogic.EventLogPath(s.eventsDir, id)
+	history, events, err := gamelogic.OpenEventLog(path, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(events) > 0 {
+		world, err := gamelogic.ReplayWorld(events, nil)
+		if err != nil {
+			history.Close()
+			return nil, nil, fmt.Errorf("failed to recover game %v: %v", id, err)
+		}
+		if !world.IsOver() {
+			err = recoverWorld(s, history, world, events)
+			if err != nil {
+				history.Close()
+				return nil, nil, err
+			}
+			return history, world, nil
+		}
+
+		archived, err := history.Archive(time.Now())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to archive finished game %v: %v", id, err)
+		}
+		log.Printf("Game %v had already ended, its history was archived to %v.\n", id, archived)
+		history, _, err = gamelogic.OpenEventLog(path, id)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rules := s.rules
+	world := gamelogic.NewWorld(rules)
+	_, _, err = history.Record(world, gamelogic.WorldEvent{
+		Kind:  gamelogic.EventCreate,
+		Rules: &rules,
+	})
+	if err != nil {
+		history.Close()
+		return nil, nil, err
+	}
+	return history, world, nil
+}
+
+// recoverWorld records that a rebuilt game is being picked up again, which
+// stops its clock for as long as the server was down.
+func recoverWorld(s *server, history *gamelogic.EventLog, world *gamelogic.World, events []gamelogic.WorldEvent) error {
+	id := events[0].GameID
+	if world.Rules.Hash() != s.rules.Hash() {
+		log.Printf("WARNING: game %v keeps the rules it was created with, %q, not the server's %q. Start with -fresh to play it with the server's rules.\n",
+			id, world.Rules.Name, s.rules.Name)
+	}
+	now := time.Now()
+	downtime := now.Sub(events[len(events)-1].At)
+	_, _, err := history.Record(world, gamelogic.WorldEvent{
+		At:      now,
+		Kind:    gamelogic.EventRecover,
+		Recover: &gamelogic.Recovery{Downtime: downtime},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to recover game %v: %v", id, err)
+	}
+	log.Printf("Recovered game %v from %d events, after %v down.\n", id, len(events), downtime.Round(time.Second))
+	return nil
+}
+
+func (g *game) setup() error {
+	/**************************************************************************
+	Ruleset
+	**************************************************************************/
+	_, _, err := pubsub.DeclareAndBind(
+		g.conn,
+		routing.ExchangePerilDirect,
+		routing.Key(g.id, routing.RulesetKey),
+		routing.Key(g.id, routing.RulesetKey),
+		routing.Retained,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare and bind ruleset queue: %v", err)
+	}
+
+	announcement := gamelogic.NewRulesetAnnouncement(g.rules)
+	err = pubsub.PublishJSON(
+		g.ch,
+		routing.ExchangePerilDirect,
+		routing.Key(g.id, routing.RulesetKey),
+		announcement,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to publish ruleset: %v", err)
+	}
+	log.Printf("Published ruleset %q (%v) for game %v.\n", g.rules.Name, announcement.Hash, g.id)
+
+	/**************************************************************************
+	Pause
+	**************************************************************************/
+	// Every pause and resume is kept in a retained queue for clients that
+	// join later. It also carries the state over a server restart.
+	state, ok, err := pubsub.PeekJSON[routing.PlayingState](
+		g.conn,
+		routing.ExchangePerilDirect,
+		routing.Key(g.id, routing.PauseStateKey),
+		routing.Key(g.id, routing.PauseKey),
+		routing.Retained,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to read pause state: %v", err)
+	}
+	// The history has the last pause state recorded, so an older one in the
+	// queue was put back there
+	recorded := g.world.PauseState()
+	if ok && state.Version < recorded.Version {
+		log.Printf("Ignoring pause state %d of game %v, which is older than the recorded %d.\n", state.Version, g.id, recorded.Version)
+	}
+	if !ok || state.Version < recorded.Version {
+		state = recorded
+	}
+	g.pauseState = state
+	g.paused.Store(state.IsPaused)
+	if state.IsPaused {
+		log.Printf("Game %v is still paused: %v.\n", g.id, state.Reason)
+	}
+
+	/**************************************************************************
+	GameLogs
+	**************************************************************************/
+	g.logSub, err = pubsub.SubscribeGobDeferred(
+		g.conn,
+		routing.ExchangePerilTopic,
+		routing.Key(g.id, routing.GameLogSlug),
+		routing.Pattern(g.id, routing.GameLogSlug),
+		routing.Durable,
+		func(gl routing.GameLog) string { return gl.Username },
+		handlerGameLog(g.logs, g.pendingLogs),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to game logs: %v", err)
+	}
+
+	/**************************************************************************
+	World
+	**************************************************************************/
+	// Every server instance keeps its own view of the world, so it needs its
+	// own copy of every spawn, move and battle. Each is published under its
+	// sender's username, and messages that claim to be from someone else are
+	// rejected
+
+	err = pubsub.SubscribeJSONFrom(
+		g.conn,
+		routing.ExchangePerilTopic,
+		routing.Key(g.id, routing.ArmySpawnsPrefix, serverID),
+		routing.Pattern(g.id, routing.ArmySpawnsPrefix),
+		routing.Transient,
+		func(spawn gamelogic.UnitSpawn) string { return spawn.Player.Username },
+		handlerSpawn(g),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to spawns: %v", err)
+	}
+
+	err = pubsub.SubscribeJSONFrom(
+		g.conn,
+		routing.ExchangePerilTopic,
+		routing.Key(g.id, routing.ArmyMovesPrefix, serverID),
+		routing.Pattern(g.id, routing.ArmyMovesPrefix),
+		routing.Transient,
+		func(move gamelogic.ArmyMove) string { return move.Player.Username },
+		handlerMove(g),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to moves: %v", err)
+	}
+
+	// Only the server declares battles, so they are control messages
+	err = pubsub.SubscribeJSON(
+		g.conn,
+		routing.ExchangePerilTopic,
+		routing.Key(g.id, routing.BattlesPrefix, serverID),
+		routing.Pattern(g.id, routing.BattlesPrefix),
+		routing.Transient,
+		handlerBattle(g),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to battles: %v", err)
+	}
+
+	/**************************************************************************
+	Presence
+	**************************************************************************/
+	err = pubsub.SubscribeJSONFrom(
+		g.conn,
+		routing.ExchangePerilTopic,
+		routing.Key(g.id, routing.PresencePrefix, serverID),
+		routing.Pattern(g.id, routing.PresencePrefix),
+		routing.Transient,
+		func(ev gamelogic.PresenceEvent) string { return ev.Username },
+		handlerPresence(g),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to presence: %v", err)
+	}
+
+	/**************************************************************************
+	Sessions
+	**************************************************************************/
+	err = pubsub.RespondJSON(
+		g.conn,
+		routing.ExchangePerilDirect,
+		routing.Key(g.id, routing.SessionKey),
+		routing.Key(g.id, routing.SessionKey),
+		routing.Transient,
+		handlerSession(g),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to serve sessions: %v", err)
+	}
+	return nil
+}
+
+// gameLogsDrainTimeout is how long closing a game waits for the logs still
+// in its queue to be written.
+const gameLogsDrainTimeout = 10 * time.Second
+
+// deleteGameLogs deletes the game's log queue once no log in it can be lost.
+// The queue is left to our consumer to empty, then the consumer is stopped
+// and every log it took is written out and acked. Only then is the queue
+// deleted, and only if it is empty. If logs are still queued or being
+// written after gameLogsDrainTimeout, or arrive meanwhile, the queue is
+// kept for them.
+func (g *game) deleteGameLogs() error {
+	queue := routing.Key(g.id, routing.GameLogSlug)
+	deadline := time.Now().Add(gameLogsDrainTimeout)
+	for {
+		status := pubsub.InspectQueues(g.conn, []string{queue})[0]
+		if status.Err != "" {
+			return errors.New(status.Err)
+		}
+		if status.Messages == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	err := g.logSub.Stop()
+	if err != nil {
+		return fmt.Errorf("failed to stop consuming logs: %v", err)
+	}
+	g.logs.Flush()
+	written := make(chan struct{})
+	go func() {
+		g.pendingLogs.Wait()
+		close(written)
+	}()
+	select {
+	case <-written:
+	case <-time.After(gameLogsDrainTimeout):
+		return fmt.Errorf("logs were still being written after %v, the queue was kept for them", gameLogsDrainTimeout)
+	}
+
+	ch, err := g.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	_, err = ch.QueueDelete(queue, false, true, false)
+	if err != nil {
+		return fmt.Errorf("logs are still queued, the queue was kept for them: %v", err)
+	}
+	return nil
+}
+
+// close ends the game for every client, stops its consumers, deletes its
+// durable queues and archives its history.
+func (g *game) close() error {
+	err := pubsub.PublishJSON(
+		g.ch,
+		routing.ExchangePerilDirect,
+		routing.Key(g.id, routing.GameOverKey),
+		gamelogic.GameOver{
+			Reason:    "the game was closed by the server",
+			Standings: g.world.Standings(),
+		},
+	)
+	if err != nil {
+		log.Printf("Failed to tell clients game %v is closed: %v\n", g.id, err)
+	}
+	close(g.done)
+
+	for _, queue := range []string{
+		routing.Key(g.id, routing.RulesetKey),
+		routing.Key(g.id, routing.PauseStateKey),
+	} {
+		_, err = g.ch.QueueDelete(queue, false, false, false)
+		if err != nil {
+			log.Printf("Failed to delete queue %v: %v\n", queue, err)
+		}
+	}
+	err = g.deleteGameLogs()
+	if err != nil {
+		log.Printf("Failed to delete game log queue of game %v: %v\n", g.id, err)
+	}
+
+	err = g.schedule.Remove()
+	if err != nil {
+		log.Printf("Failed to remove pause schedule of game %v: %v\n", g.id, err)
+	}
+
+	archived, err := g.history.Archive(time.Now())
+	if err != nil {
+		log.Printf("Failed to archive history of game %v: %v\n", g.id, err)
+	} else {
+		log.Printf("History of game %v archived to %v.\n", g.id, archived)
+	}
+	return g.conn.Close()
+}
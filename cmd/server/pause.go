@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// pause pauses the game until resumeAt, or until it is resumed if resumeAt
+// is zero.
+func (g *game) pause(reason string, resumeAt time.Time) error {
+	return g.publishPause(routing.PlayingState{
+		IsPaused: true,
+		Reason:   reason,
+		ResumeAt: resumeAt,
+	})
+}
+
+func (g *game) resume(reason string) error {
+	return g.publishPause(routing.PlayingState{
+		IsPaused: false,
+		Reason:   reason,
+	})
+}
+
+// warnPause tells clients a scheduled pause is coming without changing
+// whether the game is paused, or when a timed pause it is in ends.
+func (g *game) warnPause(w gamelogic.PauseWindow) error {
+	g.pauseMu.Lock()
+	defer g.pauseMu.Unlock()
+	return g.publishPauseLocked(routing.PlayingState{
+		IsPaused:  g.pauseState.IsPaused,
+		ResumeAt:  g.pauseState.ResumeAt,
+		Reason:    w.Reason,
+		PausingAt: w.Start,
+	})
+}
+
+func (g *game) publishPause(state routing.PlayingState) error {
+	g.pauseMu.Lock()
+	defer g.pauseMu.Unlock()
+	return g.publishPauseLocked(state)
+}
+
+func (g *game) publishPauseLocked(state routing.PlayingState) error {
+	state.Version = g.pauseState.Version + 1
+	err := pubsub.PublishJSON(
+		g.ch,
+		routing.ExchangePerilDirect,
+		routing.Key(g.id, routing.PauseKey),
+		state,
+	)
+	if err != nil {
+		return err
+	}
+	_, _, err = g.history.Record(g.world, gamelogic.WorldEvent{
+		Kind:  gamelogic.EventPause,
+		Pause: &state,
+	})
+	if err != nil {
+		log.Printf("Failed to record pause state: %v\n", err)
+	}
+	g.pauseState = state
+	g.paused.Store(state.IsPaused)
+	return nil
+}
+
+func (g *game) getPauseState() routing.PlayingState {
+	g.pauseMu.Lock()
+	defer g.pauseMu.Unlock()
+	return g.pauseState
+}
+
+// runPauseScheduler starts scheduled pauses, warns clients before they
+// start and ends timed pauses.
+func runPauseScheduler(g *game) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	// warned holds the smallest warning sent for each upcoming pause
+	warned := map[time.Time]time.Duration{}
+	for {
+		select {
+		case <-g.done:
+			return
+		case now := <-ticker.C:
+			state := g.getPauseState()
+			if state.IsPaused && !state.ResumeAt.IsZero() && !now.Before(state.ResumeAt) {
+				log.Printf("[%v] Resuming after a timed pause.\n", g.id)
+				err := g.resume("the pause is over")
+				if err != nil {
+					log.Printf("Failed to publish resume message: %v\n", err)
+				}
+			}
+
+			if w, ok, err := g.schedule.Due(now); ok {
+				if err != nil {
+					log.Printf("Failed to save pause schedule: %v\n", err)
+				}
+				delete(warned, w.Start)
+				log.Printf("[%v] Starting scheduled pause: %v.\n", g.id, w.Reason)
+				err := g.pause(w.Reason, w.ResumeAt(now))
+				if err != nil {
+					log.Printf("Failed to publish pause message: %v\n", err)
+				}
+				continue
+			}
+
+			next, ok := g.schedule.Next()
+			if !ok {
+				continue
+			}
+			// Warn once per threshold the pause is now within, only the
+			// closest one if it was scheduled at short notice
+			left := next.Start.Sub(now)
+			closest := time.Duration(0)
+			for _, warning := range gamelogic.PauseWarnings {
+				if left <= warning && (closest == 0 || warning < closest) {
+					closest = warning
+				}
+			}
+			if sent, ok := warned[next.Start]; closest == 0 || ok && sent <= closest {
+				continue
+			}
+			warned[next.Start] = closest
+			err := g.warnPause(next)
+			if err != nil {
+				log.Printf("Failed to publish pause warning: %v\n", err)
+			}
+		}
+	}
+}
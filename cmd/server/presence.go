@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func handlerPresence(g *game) func(gamelogic.PresenceEvent) routing.AckType {
+	return func(ev gamelogic.PresenceEvent) routing.AckType {
+		now := time.Now()
+		if ev.Kind != gamelogic.PresenceLeave {
+			g.clocks.Observe(ev.Username, ev.SentAt, now)
+		}
+		change, ok := g.roster.Apply(ev, now)
+		if !ok {
+			return routing.Ack
+		}
+		// Players that come back hold their username again
+		if change.Online {
+			err := g.registry.Hold(ev.Username, ev.PublicKey)
+			if err != nil {
+				log.Printf("Failed to hold username %v: %v\n", ev.Username, err)
+			}
+		}
+		publishPresence(g, change)
+		return routing.Ack
+	}
+}
+
+// publishPresence broadcasts a presence change to every client in the game.
+// Players that went offline give up their username.
+func publishPresence(g *game, change gamelogic.PresenceChange) {
+	log.Printf("[%v] %v\n", g.id, change)
+	if !change.Online {
+		err := g.registry.Release(change.Username)
+		if err != nil {
+			log.Printf("Failed to save username registry: %v\n", err)
+		}
+	}
+
+	err := pubsub.PublishJSON(
+		g.ch,
+		routing.ExchangePerilDirect,
+		routing.Key(g.id, routing.PresenceChangesKey),
+		change,
+	)
+	if err != nil {
+		log.Printf("Failed to publish presence change: %v\n", err)
+	}
+}
+
+// runClaimMonitor frees the usernames of players that claimed them but never
+// showed up to play.
+func runClaimMonitor(registry *gamelogic.Registry) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		expired, err := registry.Expire(now)
+		if err != nil {
+			log.Printf("Failed to save username registry: %v\n", err)
+		}
+		for _, username := range expired {
+			log.Printf("Released username %v, which was never seen playing.\n", username)
+		}
+	}
+}
+
+// runPresenceMonitor marks players whose heartbeats have stopped as offline.
+func runPresenceMonitor(g *game) {
+	ticker := time.NewTicker(gamelogic.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.done:
+			return
+		case now := <-ticker.C:
+			for _, change := range g.roster.Expire(now) {
+				publishPresence(g, change)
+			}
+		}
+	}
+}
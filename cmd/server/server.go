@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// server owns every game this process hosts and the lobby players use to
+// find them. Games are created and closed both from the REPL and the lobby.
+type server struct {
+	url string
+	// conn is the server's own connection, used for the lobby and registry
+	conn  *amqp.Connection
+	rules gamelogic.Rules
+	lobby *gamelogic.Lobby
+	// registry holds the usernames in use across every game
+	registry *gamelogic.Registry
+	bans     *gamelogic.BanList
+	// logs writes the game logs of every game
+	logs *gamelogic.LogWriter
+	// clocks estimates the clock skew of every client
+	clocks *gamelogic.ClockSkews
+	// eventsDir holds the history of every game
+	eventsDir string
+	games     map[string]*game
+	mu        *sync.Mutex
+}
+
+func newServer(url string, conn *amqp.Connection, rules gamelogic.Rules, registry *gamelogic.Registry, bans *gamelogic.BanList, logs *gamelogic.LogWriter, clocks *gamelogic.ClockSkews, eventsDir string) *server {
+	return &server{
+		conn:      conn,
+		url:       url,
+		rules:     rules,
+		lobby:     gamelogic.NewLobby(),
+		registry:  registry,
+		bans:      bans,
+		logs:      logs,
+		clocks:    clocks,
+		eventsDir: eventsDir,
+		games:     map[string]*game{},
+		mu:        &sync.Mutex{},
+	}
+}
+
+func (s *server) createGame(id string) (*game, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.games[id]; ok {
+		return nil, fmt.Errorf("game %v already exists", id)
+	}
+	g, err := startGame(s, id)
+	if err != nil {
+		return nil, err
+	}
+	s.games[id] = g
+	s.lobby.Open(id, g.rules)
+	return g, nil
+}
+
+func (s *server) closeGame(id string) error {
+	s.mu.Lock()
+	g, ok := s.games[id]
+	delete(s.games, id)
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no game %v", id)
+	}
+	s.lobby.Close(id)
+	return g.close()
+}
+
+func (s *server) getGame(id string) (*game, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.games[id]
+	return g, ok
+}
+
+func (s *server) gameIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := []string{}
+	for id := range s.games {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func handlerLobby(s *server) func(gamelogic.LobbyRequest) gamelogic.LobbyResponse {
+	return func(req gamelogic.LobbyRequest) gamelogic.LobbyResponse {
+		if s.bans.IsBanned(req.Username) {
+			return gamelogic.LobbyResponse{Error: fmt.Sprintf("username %s is banned", req.Username)}
+		}
+		switch req.Action {
+		case gamelogic.LobbyActionList:
+			return gamelogic.LobbyResponse{Games: s.lobby.List()}
+		case gamelogic.LobbyActionCreate:
+			_, err := s.createGame(req.GameID)
+			if err != nil {
+				return gamelogic.LobbyResponse{Error: err.Error()}
+			}
+			log.Printf("%v created game %v.\n", req.Username, req.GameID)
+			return lobbyResponse(s.lobby.Join(req.GameID, req.Username))
+		case gamelogic.LobbyActionJoin:
+			return lobbyResponse(s.lobby.Join(req.GameID, req.Username))
+		case gamelogic.LobbyActionLeave:
+			return lobbyResponse(s.lobby.Leave(req.GameID, req.Username))
+		case gamelogic.LobbyActionUnready:
+			return lobbyResponse(s.lobby.Unready(req.GameID, req.Username))
+		case gamelogic.LobbyActionReady:
+			lg, start, err := s.lobby.Ready(req.GameID, req.Username)
+			if err != nil {
+				return gamelogic.LobbyResponse{Error: err.Error()}
+			}
+			if start {
+				err = s.startMatch(lg)
+				if err != nil {
+					log.Printf("Failed to start game %v: %v\n", lg.ID, err)
+					return gamelogic.LobbyResponse{Error: err.Error()}
+				}
+			}
+			return gamelogic.LobbyResponse{Game: lg}
+		default:
+			return gamelogic.LobbyResponse{Error: fmt.Sprintf("unknown lobby action %q", req.Action)}
+		}
+	}
+}
+
+func handlerRegistry(s *server) func(gamelogic.RegistryRequest) gamelogic.RegistryResponse {
+	return func(req gamelogic.RegistryRequest) gamelogic.RegistryResponse {
+		switch req.Action {
+		case gamelogic.RegistryActionClaim:
+			if s.bans.IsBanned(req.Username) {
+				return gamelogic.RegistryResponse{Error: fmt.Sprintf("username %s is banned", req.Username)}
+			}
+			key := ed25519.PublicKey(req.PublicKey)
+			if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, gamelogic.ClaimMessage(req.Username, key), req.Proof) {
+				return gamelogic.RegistryResponse{Error: "a claim must be signed with the key it claims the username for"}
+			}
+			err := s.registry.Claim(req.Username, key)
+			if err != nil {
+				return gamelogic.RegistryResponse{Error: err.Error()}
+			}
+			log.Printf("%v has claimed their username.\n", req.Username)
+			return gamelogic.RegistryResponse{}
+		case gamelogic.RegistryActionRelease:
+			key, ok := s.registry.PublicKey(req.Username)
+			if !ok || !ed25519.Verify(key, gamelogic.ReleaseMessage(req.Username), req.Proof) {
+				return gamelogic.RegistryResponse{Error: "only the holder of a username can release it"}
+			}
+			err := s.registry.Release(req.Username)
+			if err != nil {
+				log.Printf("Failed to save username registry: %v\n", err)
+			}
+			log.Printf("%v has released their username.\n", req.Username)
+			return gamelogic.RegistryResponse{}
+		case gamelogic.RegistryActionKey:
+			key, ok := s.publicKey(req.Username)
+			if !ok {
+				return gamelogic.RegistryResponse{Error: fmt.Sprintf("no key for %s", req.Username)}
+			}
+			return gamelogic.RegistryResponse{PublicKey: key}
+		default:
+			return gamelogic.RegistryResponse{Error: fmt.Sprintf("unknown registry action %q", req.Action)}
+		}
+	}
+}
+
+func lobbyResponse(lg gamelogic.LobbyGame, err error) gamelogic.LobbyResponse {
+	if err != nil {
+		return gamelogic.LobbyResponse{Error: err.Error(), Game: lg}
+	}
+	return gamelogic.LobbyResponse{Game: lg}
+}
+
+// startMatch assigns starting locations and tells the players to begin.
+func (s *server) startMatch(lg gamelogic.LobbyGame) error {
+	g, ok := s.getGame(lg.ID)
+	if !ok {
+		return fmt.Errorf("no game %v", lg.ID)
+	}
+	starts, err := gamelogic.AssignStartingLocations(g.rules, lg.Players)
+	if err != nil {
+		return err
+	}
+	start := gamelogic.MatchStart{
+		GameID:            g.id,
+		StartingLocations: starts,
+	}
+	_, changes, err := g.history.Record(g.world, gamelogic.WorldEvent{
+		Kind:  gamelogic.EventMatchStart,
+		Start: &start,
+	})
+	if err != nil {
+		return err
+	}
+	publishOwnership(g, changes)
+
+	err = pubsub.PublishJSON(
+		g.ch,
+		routing.ExchangePerilDirect,
+		routing.Key(g.id, routing.MatchStartKey),
+		start,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to publish match start: %v", err)
+	}
+	log.Printf("Game %v has started with %v.\n", g.id, lg.Players)
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// serverStatus is what the `status` command prints and the status endpoint
+// serves.
+type serverStatus struct {
+	CheckedAt time.Time
+	Connected bool
+	Queues    []pubsub.QueueStatus
+	GameLog   gamelogic.LogWriterStats
+	// ClockSkews is how far ahead of the server each client's clock is
+	ClockSkews map[string]time.Duration
+	Games      []gameStatus
+}
+
+type gameStatus struct {
+	ID        string
+	Connected bool
+	Pause     routing.PlayingState
+	Schedule  []gamelogic.PauseWindow
+	Players   []gamelogic.PlayerPresence
+	Queues    []pubsub.QueueStatus
+}
+
+func (s *server) status() serverStatus {
+	status := serverStatus{
+		CheckedAt:  time.Now(),
+		Connected:  !s.conn.IsClosed(),
+		GameLog:    s.logs.Stats(),
+		ClockSkews: s.clocks.Skews(),
+		Games:      []gameStatus{},
+	}
+	if status.Connected {
+		status.Queues = pubsub.InspectQueues(s.conn, []string{routing.LobbyKey, routing.RegistryKey})
+	}
+	for _, id := range s.gameIDs() {
+		g, ok := s.getGame(id)
+		if !ok {
+			continue
+		}
+		gs := gameStatus{
+			ID:        g.id,
+			Connected: !g.conn.IsClosed(),
+			Pause:     g.getPauseState(),
+			Schedule:  g.schedule.Windows(),
+			Players:   g.roster.Players(),
+		}
+		if gs.Connected {
+			gs.Queues = pubsub.InspectQueues(g.conn, g.queues())
+		}
+		status.Games = append(status.Games, gs)
+	}
+	return status
+}
+
+func (st serverStatus) print(current *game) {
+	fmt.Printf("Server status at %s:\n", st.CheckedAt.Format(time.TimeOnly))
+	fmt.Printf("* RabbitMQ connection: %s\n", health(st.Connected))
+	fmt.Printf("* Game log: %v\n", st.GameLog)
+	printClockSkews(st.ClockSkews)
+	printQueues(st.Queues)
+	for _, gs := range st.Games {
+		marker := ""
+		if current != nil && gs.ID == current.id {
+			marker = " (selected)"
+		}
+		fmt.Println()
+		fmt.Printf("Game %s%s:\n", gs.ID, marker)
+		fmt.Printf("* RabbitMQ connection: %s\n", health(gs.Connected))
+		gamelogic.PrintPauseStatus(gs.Pause, gs.Schedule)
+		gamelogic.PrintPlayers(gs.Players)
+		printQueues(gs.Queues)
+	}
+}
+
+func printClockSkews(skews map[string]time.Duration) {
+	fmt.Println("Client clocks:")
+	if len(skews) == 0 {
+		fmt.Println("* none heard from")
+	}
+	users := []string{}
+	for username := range skews {
+		users = append(users, username)
+	}
+	sort.Strings(users)
+	for _, username := range users {
+		skew := skews[username].Round(time.Millisecond)
+		if skew < 0 {
+			fmt.Printf("* %s: %v behind\n", username, -skew)
+			continue
+		}
+		fmt.Printf("* %s: %v ahead\n", username, skew)
+	}
+}
+
+func printQueues(queues []pubsub.QueueStatus) {
+	fmt.Println("Queues:")
+	for _, q := range queues {
+		if q.Err != "" {
+			fmt.Printf("* %s: %s\n", q.Name, q.Err)
+			continue
+		}
+		fmt.Printf("* %s: %d message(s), %d consumer(s)\n", q.Name, q.Messages, q.Consumers)
+	}
+}
+
+func health(connected bool) string {
+	if connected {
+		return "connected"
+	}
+	return "closed"
+}
+
+// serveStatus serves the server status as JSON at /status on addr.
+func serveStatus(s *server, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(s.status())
+		if err != nil {
+			log.Printf("Failed to write status: %v\n", err)
+		}
+	})
+	log.Printf("Serving status on http://%v/status\n", addr)
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		log.Printf("Status endpoint stopped: %v\n", err)
+	}
+}
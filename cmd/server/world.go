@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func handlerSpawn(g *game) func(gamelogic.UnitSpawn) routing.AckType {
+	return func(spawn gamelogic.UnitSpawn) routing.AckType {
+		_, changes, err := g.history.Record(g.world, gamelogic.WorldEvent{
+			Kind:  gamelogic.EventSpawn,
+			Spawn: &spawn,
+		})
+		if errors.Is(err, gamelogic.ErrRejected) {
+			log.Printf("[%v] Rejecting spawn from %v: %v\n", g.id, spawn.Player.Username, err)
+			return routing.NackDiscard
+		}
+		if err != nil {
+			log.Printf("Failed to record spawn: %v\n", err)
+			return routing.NackRequeue
+		}
+		publishOwnership(g, changes)
+		if battle, ok := g.world.DeclareSpawnBattle(spawn); ok {
+			declareBattle(g, battle)
+		}
+		checkVictory(g)
+		return routing.Ack
+	}
+}
+
+func handlerMove(g *game) func(gamelogic.ArmyMove) routing.AckType {
+	return func(move gamelogic.ArmyMove) routing.AckType {
+		_, changes, err := g.history.Record(g.world, gamelogic.WorldEvent{
+			Kind: gamelogic.EventMove,
+			Move: &move,
+		})
+		if errors.Is(err, gamelogic.ErrRejected) {
+			log.Printf("[%v] Rejecting move from %v: %v\n", g.id, move.Player.Username, err)
+			return routing.NackDiscard
+		}
+		if err != nil {
+			log.Printf("Failed to record move: %v\n", err)
+			return routing.NackRequeue
+		}
+		publishOwnership(g, changes)
+		if battle, ok := g.world.DeclareBattle(move); ok {
+			declareBattle(g, battle)
+		}
+		checkVictory(g)
+		return routing.Ack
+	}
+}
+
+// declareBattle starts a battle caused by a move or a spawn. The world has
+// already been updated, so a failed publish is only logged. The battle is
+// named after its cause, so declaring it again for the same move or spawn,
+// from a redelivery or another server, is ignored by everyone who fought it.
+func declareBattle(g *game, battle gamelogic.Battle) {
+	log.Printf("[%v] %v started a battle in %v.\n", g.id, battle.Aggressor, battle.Location)
+	err := pubsub.PublishJSON(
+		g.ch,
+		routing.ExchangePerilTopic,
+		routing.Key(g.id, routing.BattlesPrefix, gamelogic.ServerName),
+		battle,
+	)
+	if err != nil {
+		log.Printf("Failed to publish battle: %v\n", err)
+	}
+}
+
+func handlerBattle(g *game) func(gamelogic.Battle) routing.AckType {
+	return func(battle gamelogic.Battle) routing.AckType {
+		// The battle is recorded as resolved, so a replay does not depend on
+		// the combat rules
+		report := gamelogic.ResolveBattle(g.rules, battle)
+		_, changes, err := g.history.Record(g.world, gamelogic.WorldEvent{
+			Kind:   gamelogic.EventBattle,
+			Battle: &report,
+		})
+		if err != nil {
+			log.Printf("Failed to record battle: %v\n", err)
+			return routing.NackRequeue
+		}
+		publishOwnership(g, changes)
+		checkVictory(g)
+		return routing.Ack
+	}
+}
+
+func handlerSession(g *game) func(gamelogic.SessionRequest) gamelogic.SessionResponse {
+	return func(req gamelogic.SessionRequest) gamelogic.SessionResponse {
+		key, ok := g.registry.PublicKey(req.Username)
+		if !ok || !ed25519.Verify(key, gamelogic.SessionMessage(g.id, req.Username), req.Proof) {
+			return gamelogic.SessionResponse{Error: "only a player can ask for their own session"}
+		}
+		session := g.world.Session(req.Username)
+		session.Pause = g.getPauseState()
+		return session
+	}
+}
+
+// publishOwnership broadcasts ownership changes. The world has already been
+// updated, so a failed publish is only logged rather than requeued.
+func publishOwnership(g *game, changes []gamelogic.OwnershipChange) {
+	for _, change := range changes {
+		log.Printf("[%v] %v\n", g.id, change)
+		err := pubsub.PublishJSON(
+			g.ch,
+			routing.ExchangePerilTopic,
+			routing.Key(g.id, routing.OwnershipPrefix, string(change.Location)),
+			change,
+		)
+		if err != nil {
+			log.Printf("Failed to publish ownership change: %v\n", err)
+		}
+	}
+}
+
+// checkVictory ends the game if a victory condition is met: it broadcasts the
+// result to every client and writes the final standings to the game log.
+func checkVictory(g *game) {
+	over, ok, err := g.history.RecordVictory(g.world, time.Now())
+	if !ok {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to record game over: %v\n", err)
+		return
+	}
+	log.Printf("[%v] %v\n", g.id, over.Summary())
+
+	err = pubsub.PublishJSON(
+		g.ch,
+		routing.ExchangePerilDirect,
+		routing.Key(g.id, routing.GameOverKey),
+		over,
+	)
+	if err != nil {
+		log.Printf("Failed to publish game over: %v\n", err)
+	}
+
+	g.logs.Write(routing.GameLog{
+		ID:          gamelogic.NewLogID(),
+		GameID:      g.id,
+		Event:       routing.LogEventGameOver,
+		CurrentTime: over.EndedAt,
+		Message:     over.Summary(),
+		Username:    gamelogic.ServerName,
+	}, func(err error) {
+		if err != nil {
+			log.Printf("Failed to write final standings: %v\n", err)
+		}
+	})
+}
+
+// runVictoryClock checks the victory conditions every second so a time
+// limit ends the game even when nobody is moving.
+func runVictoryClock(g *game) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.done:
+			return
+		case <-ticker.C:
+			if g.world.IsOver() {
+				return
+			}
+			checkVictory(g)
+		}
+	}
+}
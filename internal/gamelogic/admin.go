@@ -0,0 +1,152 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Announcement is a message from the server admin that every client prints.
+type Announcement struct {
+	Message string
+	SentAt  time.Time
+}
+
+// Kick tells a client to disconnect. Banned is set when the player may not
+// come back.
+type Kick struct {
+	Username string
+	Reason   string
+	Banned   bool
+}
+
+type Ban struct {
+	Username string
+	Reason   string
+	BannedAt time.Time
+}
+
+// BanList is the server's list of banned usernames. Every change is written
+// to its file, so bans survive a restart.
+type BanList struct {
+	path string
+	bans map[string]Ban
+	mu   *sync.RWMutex
+}
+
+// LoadBanList reads the ban list at path. A missing file is an empty list.
+func LoadBanList(path string) (*BanList, error) {
+	bl := &BanList{
+		path: path,
+		bans: map[string]Ban{},
+		mu:   &sync.RWMutex{},
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return bl, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	bans := []Ban{}
+	err = json.Unmarshal(data, &bans)
+	if err != nil {
+		return nil, fmt.Errorf("failed JSON unmarshal ban list: %v", err)
+	}
+	for _, b := range bans {
+		bl.bans[b.Username] = b
+	}
+	return bl, nil
+}
+
+func (bl *BanList) Ban(username, reason string) error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	bl.bans[username] = Ban{
+		Username: username,
+		Reason:   reason,
+		BannedAt: time.Now(),
+	}
+	return bl.save()
+}
+
+func (bl *BanList) Unban(username string) error {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	if _, ok := bl.bans[username]; !ok {
+		return fmt.Errorf("%s is not banned", username)
+	}
+	delete(bl.bans, username)
+	return bl.save()
+}
+
+func (bl *BanList) IsBanned(username string) bool {
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	_, ok := bl.bans[username]
+	return ok
+}
+
+// Bans returns every ban, sorted by username.
+func (bl *BanList) Bans() []Ban {
+	bl.mu.RLock()
+	defer bl.mu.RUnlock()
+	return bl.sorted()
+}
+
+func (bl *BanList) sorted() []Ban {
+	bans := []Ban{}
+	for _, b := range bl.bans {
+		bans = append(bans, b)
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Username < bans[j].Username
+	})
+	return bans
+}
+
+func (bl *BanList) save() error {
+	data, err := json.MarshalIndent(bl.sorted(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed JSON marshal ban list: %v", err)
+	}
+	tmp := bl.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, bl.path)
+}
+
+func PrintBans(bans []Ban) {
+	fmt.Println("Banned players:")
+	if len(bans) == 0 {
+		fmt.Println("* none")
+	}
+	for _, b := range bans {
+		fmt.Printf("* %s since %s: %s\n", b.Username, b.BannedAt.Format(time.DateTime), b.Reason)
+	}
+}
+
+func (gs *GameState) HandleAnnouncement(a Announcement) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Announcement ====")
+	fmt.Printf("[%s] %s\n", a.SentAt.Format(time.TimeOnly), a.Message)
+}
+
+// HandleKick prints why we were kicked. The caller disconnects.
+func (gs *GameState) HandleKick(k Kick) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	if k.Banned {
+		fmt.Println("==== You Have Been Banned ====")
+	} else {
+		fmt.Println("==== You Have Been Kicked ====")
+	}
+	fmt.Printf("Reason: %s\n", k.Reason)
+}
@@ -0,0 +1,60 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+)
+
+func (gs *GameState) CommandAlly(words []string) error {
+	if len(words) < 2 {
+		return errors.New("usage: ally <username>")
+	}
+	username := words[1]
+	if username == gs.GetUsername() {
+		return errors.New("error: you can not ally with yourself")
+	}
+
+	defer gs.changed()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for _, ally := range gs.Player.Allies {
+		if ally == username {
+			return fmt.Errorf("error: you already offered an alliance to %s", username)
+		}
+	}
+	gs.Player.Allies = append(gs.Player.Allies, username)
+	fmt.Printf("You offered an alliance to %s. You fight together once they ally with you too.\n", username)
+	return nil
+}
+
+func (gs *GameState) CommandUnally(words []string) error {
+	if len(words) < 2 {
+		return errors.New("usage: unally <username>")
+	}
+	username := words[1]
+
+	defer gs.changed()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for i, ally := range gs.Player.Allies {
+		if ally == username {
+			gs.Player.Allies = append(gs.Player.Allies[:i], gs.Player.Allies[i+1:]...)
+			fmt.Printf("You broke your alliance with %s.\n", username)
+			return nil
+		}
+	}
+	return fmt.Errorf("error: you are not allied with %s", username)
+}
+
+func isAllied(p1, p2 Player) bool {
+	return lists(p1.Allies, p2.Username) && lists(p2.Allies, p1.Username)
+}
+
+func lists(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// skewSamples is how many heartbeats a client's clock skew is estimated
+	// from, about the last minute of them.
+	skewSamples = 12
+	// MaxClockAhead is how far a timestamp may be ahead of the server, after
+	// correcting for its client's skew, before it is implausible.
+	MaxClockAhead = 2 * time.Second
+	// MaxLogDelay is how far a timestamp may be behind the server. Game logs
+	// wait in a durable queue while the server is down, so this is generous.
+	MaxLogDelay = time.Hour
+)
+
+// ClockSkews estimates how far each client's clock is from the server's,
+// from the time their heartbeats were sent and received. A heartbeat is
+// received after it was sent, so sent minus received is the skew less the
+// network delay. The largest of the recent samples had the least delay and
+// is the best estimate.
+type ClockSkews struct {
+	samples map[string][]time.Duration
+	mu      *sync.Mutex
+}
+
+func NewClockSkews() *ClockSkews {
+	return &ClockSkews{
+		samples: map[string][]time.Duration{},
+		mu:      &sync.Mutex{},
+	}
+}
+
+func (c *ClockSkews) Observe(username string, sentAt, receivedAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	samples := append(c.samples[username], sentAt.Sub(receivedAt))
+	if len(samples) > skewSamples {
+		samples = samples[len(samples)-skewSamples:]
+	}
+	c.samples[username] = samples
+}
+
+// Skew is how far ahead of the server a client's clock is, negative if it
+// is behind. ok is false until a heartbeat has been received from them.
+func (c *ClockSkews) Skew(username string) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	samples := c.samples[username]
+	if len(samples) == 0 {
+		return 0, false
+	}
+	skew := samples[0]
+	for _, s := range samples[1:] {
+		skew = max(skew, s)
+	}
+	return skew, true
+}
+
+// Skews returns the estimated skew of every client heard from.
+func (c *ClockSkews) Skews() map[string]time.Duration {
+	skews := map[string]time.Duration{}
+	c.mu.Lock()
+	users := []string{}
+	for username := range c.samples {
+		users = append(users, username)
+	}
+	c.mu.Unlock()
+	for _, username := range users {
+		skews[username], _ = c.Skew(username)
+	}
+	return skews
+}
+
+// Check corrects a client's timestamp for its skew, 0 if it is not known
+// yet, and says why it is implausible, or returns an empty reason if it is
+// not.
+func (c *ClockSkews) Check(username string, sentAt, receivedAt time.Time) (time.Duration, string) {
+	if username == ServerName {
+		return 0, ""
+	}
+	skew, _ := c.Skew(username)
+	ahead := sentAt.Add(-skew).Sub(receivedAt)
+	switch {
+	case sentAt.IsZero():
+		return skew, "no timestamp"
+	case ahead > MaxClockAhead:
+		return skew, fmt.Sprintf("%v ahead of the server", ahead.Round(time.Millisecond))
+	case -ahead > MaxLogDelay:
+		return skew, fmt.Sprintf("%v behind the server", (-ahead).Round(time.Second))
+	default:
+		return skew, ""
+	}
+}
@@ -0,0 +1,313 @@
+package gamelogic
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// BattleReport is the full, replayable result of a battle. Resolving the same
+// battle with the same Seed always produces the same report.
+type BattleReport struct {
+	BattleID string
+	Seed     int64
+	Location Location
+	Sides    []SideReport
+	Rounds   []BattleRound
+	// Winners is empty when the battle is a draw.
+	Winners []string
+}
+
+type SideReport struct {
+	Players    []string
+	Defending  bool
+	Casualties []BattleUnit
+	Survivors  []BattleUnit
+}
+
+// BattleUnit is a unit together with the player that owns it, since unit IDs
+// are only unique per player.
+type BattleUnit struct {
+	Owner string
+	Unit
+}
+
+type BattleRound struct {
+	Number int
+	// Modifiers holds the modifier of each side, in the same order as Sides.
+	Modifiers []SideModifier
+	Hits      []Hit
+	Losses    []BattleUnit
+}
+
+// SideModifier is the damage bonus a side fights with in a round.
+type SideModifier struct {
+	CombinedArms float64
+	Defender     float64
+	Terrain      float64
+}
+
+func (m SideModifier) Multiplier() float64 {
+	return 1 + m.CombinedArms + m.Defender + m.Terrain
+}
+
+func (m SideModifier) String() string {
+	return fmt.Sprintf("x%.2f (combined arms +%.2f, defender +%.2f, terrain +%.2f)",
+		m.Multiplier(), m.CombinedArms, m.Defender, m.Terrain)
+}
+
+// Hit is a single unit's shot in a round.
+type Hit struct {
+	Shooter       BattleUnit
+	Target        BattleUnit
+	Roll          int
+	Effectiveness float64
+	Damage        float64
+}
+
+// battleSeed derives a battle's seed from its ID.
+func battleSeed(id string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return int64(h.Sum64())
+}
+
+// ResolveBattle fights every side's units in the battle's location. Each
+// round every surviving unit rolls a d6 and deals power*roll/6 damage to a
+// random unit of any enemy side, scaled by its rank's effectiveness against
+// the target and by its side's modifier. Damage carries over between rounds
+// and a unit dies once it has taken damage equal to its own power. The
+// battle ends when at most one side is left standing or the round limit is
+// reached, in which case the side with the most surviving power wins.
+func ResolveBattle(rules Rules, b Battle) BattleReport {
+	rng := rand.New(rand.NewSource(b.Seed))
+	report := BattleReport{
+		BattleID: b.ID,
+		Seed:     b.Seed,
+		Location: b.Location,
+	}
+
+	forces := [][]BattleUnit{}
+	for _, side := range b.Sides {
+		sr := SideReport{Defending: true}
+		units := []BattleUnit{}
+		for _, p := range side.Players {
+			sr.Players = append(sr.Players, p.Username)
+			if p.Username == b.Aggressor {
+				sr.Defending = false
+			}
+			for _, unit := range unitsInLocation(p, b.Location) {
+				units = append(units, BattleUnit{Owner: p.Username, Unit: unit})
+			}
+		}
+		report.Sides = append(report.Sides, sr)
+		forces = append(forces, units)
+	}
+
+	damage := map[BattleUnit]float64{}
+	for round := 1; round <= rules.maxRounds() && sidesStanding(forces) > 1; round++ {
+		br := BattleRound{Number: round}
+		for i, units := range forces {
+			br.Modifiers = append(br.Modifiers, sideModifier(rules, b.Location, units, report.Sides[i].Defending))
+		}
+		for i, units := range forces {
+			targets := enemiesOf(forces, i)
+			br.Hits = append(br.Hits, fire(rules, rng, units, targets, br.Modifiers[i], damage)...)
+		}
+		for i, units := range forces {
+			alive, dead := removeDead(rules, units, damage)
+			forces[i] = alive
+			report.Sides[i].Casualties = append(report.Sides[i].Casualties, dead...)
+			br.Losses = append(br.Losses, dead...)
+		}
+		report.Rounds = append(report.Rounds, br)
+	}
+
+	best, bestPower, tied := -1, 0, false
+	for i, units := range forces {
+		report.Sides[i].Survivors = units
+		power := rules.powerLevel(unitsOf(units))
+		if power > bestPower {
+			best, bestPower, tied = i, power, false
+		} else if power == bestPower && power > 0 {
+			tied = true
+		}
+	}
+	if best >= 0 && !tied {
+		report.Winners = report.Sides[best].Players
+	}
+	return report
+}
+
+func sidesStanding(forces [][]BattleUnit) int {
+	standing := 0
+	for _, units := range forces {
+		if len(units) > 0 {
+			standing++
+		}
+	}
+	return standing
+}
+
+func enemiesOf(forces [][]BattleUnit, side int) []BattleUnit {
+	enemies := []BattleUnit{}
+	for i, units := range forces {
+		if i != side {
+			enemies = append(enemies, units...)
+		}
+	}
+	return enemies
+}
+
+// sideModifier works out the bonuses a stack of units fights with.
+func sideModifier(rules Rules, loc Location, units []BattleUnit, defending bool) SideModifier {
+	ranks := map[UnitRank]struct{}{}
+	for _, unit := range units {
+		ranks[unit.Rank] = struct{}{}
+	}
+	m := SideModifier{}
+	if len(ranks) > 1 {
+		m.CombinedArms = rules.Combat.CombinedArmsBonus * float64(len(ranks)-1)
+	}
+	if defending {
+		m.Defender = rules.Combat.DefenderBonus
+		l, _ := rules.location(loc)
+		m.Terrain = l.DefenseBonus
+	}
+	return m
+}
+
+// fire has every unit in shooters roll against a random unit in targets and
+// records the damage dealt. It returns the hits in shooter order.
+func fire(rules Rules, rng *rand.Rand, shooters, targets []BattleUnit, mod SideModifier, damage map[BattleUnit]float64) []Hit {
+	hits := []Hit{}
+	if len(targets) == 0 {
+		return hits
+	}
+	for _, shooter := range shooters {
+		target := targets[rng.Intn(len(targets))]
+		roll := rng.Intn(6) + 1
+		eff := rules.effectiveness(shooter.Rank, target.Rank)
+		dmg := float64(rules.power(shooter.Rank)*roll) / 6 * eff * mod.Multiplier()
+		damage[target] += dmg
+		hits = append(hits, Hit{
+			Shooter:       shooter,
+			Target:        target,
+			Roll:          roll,
+			Effectiveness: eff,
+			Damage:        dmg,
+		})
+	}
+	return hits
+}
+
+func removeDead(rules Rules, units []BattleUnit, damage map[BattleUnit]float64) (alive []BattleUnit, dead []BattleUnit) {
+	for _, unit := range units {
+		if damage[unit] >= float64(rules.power(unit.Rank)) {
+			dead = append(dead, unit)
+		} else {
+			alive = append(alive, unit)
+		}
+	}
+	return alive, dead
+}
+
+func unitsOf(units []BattleUnit) []Unit {
+	result := []Unit{}
+	for _, unit := range units {
+		result = append(result, unit.Unit)
+	}
+	return result
+}
+
+// unitsInLocation returns the player's units in loc ordered by ID so battles
+// are reproducible regardless of map iteration order.
+func unitsInLocation(p Player, loc Location) []Unit {
+	units := []Unit{}
+	for _, unit := range p.Units {
+		if unit.Location == loc {
+			units = append(units, unit)
+		}
+	}
+	sort.Slice(units, func(i, j int) bool {
+		return units[i].ID < units[j].ID
+	})
+	return units
+}
+
+// CasualtiesOf returns the units username lost in the battle.
+func (r BattleReport) CasualtiesOf(username string) []Unit {
+	units := []Unit{}
+	for _, side := range r.Sides {
+		for _, unit := range side.Casualties {
+			if unit.Owner == username {
+				units = append(units, unit.Unit)
+			}
+		}
+	}
+	return units
+}
+
+func (r BattleReport) Won(username string) bool {
+	for _, winner := range r.Winners {
+		if winner == username {
+			return true
+		}
+	}
+	return false
+}
+
+func (r BattleReport) Summary() string {
+	sides := []string{}
+	losses := []string{}
+	for _, side := range r.Sides {
+		name := strings.Join(side.Players, "+")
+		sides = append(sides, name)
+		losses = append(losses, fmt.Sprintf("%v lost %d", name, len(side.Casualties)))
+	}
+
+	var result string
+	if len(r.Winners) == 0 {
+		result = fmt.Sprintf("A war between %v in %v resulted in a draw", strings.Join(sides, ", "), r.Location)
+	} else {
+		winner := strings.Join(r.Winners, "+")
+		losers := []string{}
+		for _, side := range sides {
+			if side != winner {
+				losers = append(losers, side)
+			}
+		}
+		result = fmt.Sprintf("%v won a war against %v in %v", winner, strings.Join(losers, ", "), r.Location)
+	}
+	return fmt.Sprintf("%v (%v, %d round(s), seed %d)", result, strings.Join(losses, ", "), len(r.Rounds), r.Seed)
+}
+
+func (h Hit) String() string {
+	matchup := ""
+	if h.Effectiveness > 1 {
+		matchup = fmt.Sprintf(" (x%.2f, strong against %v)", h.Effectiveness, h.Target.Rank)
+	} else if h.Effectiveness < 1 {
+		matchup = fmt.Sprintf(" (x%.2f, weak against %v)", h.Effectiveness, h.Target.Rank)
+	}
+	return fmt.Sprintf("%s's %v %v rolled %d on %s's %v %v for %.2f damage%s",
+		h.Shooter.Owner, h.Shooter.Rank, h.Shooter.ID, h.Roll,
+		h.Target.Owner, h.Target.Rank, h.Target.ID, h.Damage, matchup)
+}
+
+func (r BattleReport) Print() {
+	for _, round := range r.Rounds {
+		fmt.Printf("Round %d:\n", round.Number)
+		for i, side := range r.Sides {
+			fmt.Printf("  %s fights at %v\n", strings.Join(side.Players, "+"), round.Modifiers[i])
+		}
+		for _, hit := range round.Hits {
+			fmt.Printf("  %v\n", hit)
+		}
+		for _, unit := range round.Losses {
+			fmt.Printf("  %s lost %v %v\n", unit.Owner, unit.Rank, unit.ID)
+		}
+	}
+	fmt.Printf("Battle seed: %d\n", r.Seed)
+}
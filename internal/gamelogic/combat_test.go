@@ -0,0 +1,183 @@
+package gamelogic
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// army is a player with a unit in loc for each rank given.
+func army(username string, loc Location, ranks ...UnitRank) Player {
+	p := Player{Username: username, Units: map[int]Unit{}}
+	for i, rank := range ranks {
+		p.Units[i+1] = Unit{ID: i + 1, Rank: rank, Location: loc}
+	}
+	return p
+}
+
+// duel is a battle alice starts against bob in loc.
+func duel(seed int64, loc Location, alice, bob []UnitRank) Battle {
+	return Battle{
+		ID:        "test",
+		Seed:      seed,
+		Location:  loc,
+		Aggressor: "alice",
+		Sides: []BattleSide{
+			{Players: []Player{army("alice", loc, alice...)}},
+			{Players: []Player{army("bob", loc, bob...)}},
+		},
+	}
+}
+
+func TestResolveBattle(t *testing.T) {
+	tests := []struct {
+		name       string
+		battle     Battle
+		winners    []string
+		aliceLost  int
+		bobLost    int
+		roundCount int
+	}{
+		{
+			name:       "artillery holds off infantry",
+			battle:     duel(1, "americas", []UnitRank{RankArtillery}, []UnitRank{RankInfantry, RankInfantry}),
+			winners:    []string{"alice"},
+			aliceLost:  0,
+			bobLost:    2,
+			roundCount: 2,
+		},
+		{
+			name:       "cavalry overruns artillery",
+			battle:     duel(2, "americas", []UnitRank{RankCavalry, RankCavalry}, []UnitRank{RankArtillery}),
+			winners:    []string{"alice"},
+			aliceLost:  1,
+			bobLost:    1,
+			roundCount: 2,
+		},
+		{
+			name:       "mutual destruction is a draw",
+			battle:     duel(1, "antarctica", []UnitRank{RankInfantry, RankInfantry}, []UnitRank{RankInfantry, RankInfantry}),
+			winners:    nil,
+			aliceLost:  2,
+			bobLost:    2,
+			roundCount: 3,
+		},
+		{
+			name:       "defender holds the antarctic",
+			battle:     duel(2, "antarctica", []UnitRank{RankInfantry, RankInfantry}, []UnitRank{RankInfantry, RankInfantry}),
+			winners:    []string{"bob"},
+			aliceLost:  2,
+			bobLost:    1,
+			roundCount: 2,
+		},
+		{
+			name:       "attacker wins a skirmish",
+			battle:     duel(3, "americas", []UnitRank{RankInfantry}, []UnitRank{RankInfantry}),
+			winners:    []string{"alice"},
+			aliceLost:  0,
+			bobLost:    1,
+			roundCount: 1,
+		},
+	}
+	rules := DefaultRules()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := ResolveBattle(rules, tt.battle)
+			if !reflect.DeepEqual(report.Winners, tt.winners) {
+				t.Errorf("winners are %v, want %v", report.Winners, tt.winners)
+			}
+			if got := len(report.CasualtiesOf("alice")); got != tt.aliceLost {
+				t.Errorf("alice lost %d unit(s), want %d", got, tt.aliceLost)
+			}
+			if got := len(report.CasualtiesOf("bob")); got != tt.bobLost {
+				t.Errorf("bob lost %d unit(s), want %d", got, tt.bobLost)
+			}
+			if got := len(report.Rounds); got != tt.roundCount {
+				t.Errorf("the battle took %d round(s), want %d", got, tt.roundCount)
+			}
+			if again := ResolveBattle(rules, tt.battle); !reflect.DeepEqual(report, again) {
+				t.Error("resolving the battle again with the same seed gave a different report")
+			}
+		})
+	}
+}
+
+func TestSideModifier(t *testing.T) {
+	tests := []struct {
+		name      string
+		loc       Location
+		ranks     []UnitRank
+		defending bool
+		want      SideModifier
+		mult      float64
+	}{
+		{"lone attacker", "americas", []UnitRank{RankInfantry, RankInfantry}, false, SideModifier{}, 1},
+		{"combined arms", "americas", []UnitRank{RankInfantry, RankCavalry, RankArtillery}, false, SideModifier{CombinedArms: 0.2}, 1.2},
+		{"defender in the open", "americas", []UnitRank{RankInfantry}, true, SideModifier{Defender: 0.1}, 1.1},
+		{"attacker ignores terrain", "antarctica", []UnitRank{RankInfantry, RankCavalry}, false, SideModifier{CombinedArms: 0.1}, 1.1},
+		{"defender in the antarctic", "antarctica", []UnitRank{RankInfantry, RankCavalry}, true, SideModifier{CombinedArms: 0.1, Defender: 0.1, Terrain: 0.25}, 1.45},
+	}
+	rules := DefaultRules()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			units := []BattleUnit{}
+			for i, rank := range tt.ranks {
+				units = append(units, BattleUnit{Owner: "alice", Unit: Unit{ID: i + 1, Rank: rank, Location: tt.loc}})
+			}
+			got := sideModifier(rules, tt.loc, units, tt.defending)
+			if !closeTo(got.CombinedArms, tt.want.CombinedArms) || !closeTo(got.Defender, tt.want.Defender) || !closeTo(got.Terrain, tt.want.Terrain) {
+				t.Errorf("modifier is %v, want %v", got, tt.want)
+			}
+			if !closeTo(got.Multiplier(), tt.mult) {
+				t.Errorf("multiplier is %.2f, want %.2f", got.Multiplier(), tt.mult)
+			}
+		})
+	}
+}
+
+func TestFire(t *testing.T) {
+	tests := []struct {
+		shooter       UnitRank
+		target        UnitRank
+		effectiveness float64
+	}{
+		{RankInfantry, RankInfantry, 1},
+		{RankInfantry, RankCavalry, 1.5},
+		{RankCavalry, RankArtillery, 2},
+		{RankCavalry, RankInfantry, 0.75},
+		{RankArtillery, RankCavalry, 0.5},
+		{RankArtillery, RankInfantry, 1},
+	}
+	rules := DefaultRules()
+	mod := SideModifier{CombinedArms: 0.1, Defender: 0.1, Terrain: 0.25}
+	for _, tt := range tests {
+		t.Run(string(tt.shooter)+" on "+string(tt.target), func(t *testing.T) {
+			shooter := BattleUnit{Owner: "alice", Unit: Unit{ID: 1, Rank: tt.shooter}}
+			target := BattleUnit{Owner: "bob", Unit: Unit{ID: 1, Rank: tt.target}}
+			damage := map[BattleUnit]float64{}
+			hits := fire(rules, rand.New(rand.NewSource(1)), []BattleUnit{shooter}, []BattleUnit{target}, mod, damage)
+			if len(hits) != 1 {
+				t.Fatalf("got %d hit(s), want 1", len(hits))
+			}
+			hit := hits[0]
+			if hit.Roll < 1 || hit.Roll > 6 {
+				t.Errorf("rolled %d, want 1 to 6", hit.Roll)
+			}
+			if hit.Effectiveness != tt.effectiveness {
+				t.Errorf("effectiveness is %.2f, want %.2f", hit.Effectiveness, tt.effectiveness)
+			}
+			want := float64(rules.power(tt.shooter)*hit.Roll) / 6 * tt.effectiveness * 1.45
+			if !closeTo(hit.Damage, want) {
+				t.Errorf("dealt %.4f damage, want %.4f", hit.Damage, want)
+			}
+			if !closeTo(damage[target], want) {
+				t.Errorf("target took %.4f damage, want %.4f", damage[target], want)
+			}
+		})
+	}
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
@@ -0,0 +1,155 @@
+package gamelogic
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type Treasury struct {
+	Gold     int
+	LastTick int
+}
+
+type TickReport struct {
+	Tick       int
+	Controlled []Location
+	Income     int
+	Upkeep     int
+	Unpaid     int
+	Gold       int
+}
+
+// HandleTick pays income for every controlled location and charges upkeep
+// for every unit. Ticks are ignored while paused, after the game is over and
+// when they are not newer than the last one handled.
+func (gs *GameState) HandleTick(tick routing.EconomyTick) (TickReport, bool) {
+	if gs.isPaused() || gs.isOver() {
+		return TickReport{}, false
+	}
+
+	controlled := gs.ownedLocations()
+	upkeep := gs.upkeep()
+
+	defer gs.changed()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if tick.Tick <= gs.Treasury.LastTick {
+		return TickReport{}, false
+	}
+	gs.Treasury.LastTick = tick.Tick
+
+	report := TickReport{
+		Tick:       tick.Tick,
+		Controlled: controlled,
+		Income:     len(controlled) * gs.Rules.Economy.IncomePerLocation,
+		Upkeep:     upkeep,
+	}
+	gs.Treasury.Gold += report.Income - report.Upkeep
+	if gs.Treasury.Gold < 0 {
+		report.Unpaid = -gs.Treasury.Gold
+		gs.Treasury.Gold = 0
+	}
+	report.Gold = gs.Treasury.Gold
+	return report, true
+}
+
+func (r TickReport) Print() {
+	fmt.Println()
+	fmt.Printf("==== Economy Tick %d ====\n", r.Tick)
+	fmt.Printf("Income: +%d gold from %d location(s)\n", r.Income, len(r.Controlled))
+	fmt.Printf("Upkeep: -%d gold\n", r.Upkeep)
+	if r.Unpaid > 0 {
+		fmt.Printf("You could not pay %d gold of upkeep!\n", r.Unpaid)
+	}
+	fmt.Printf("Treasury: %d gold\n", r.Gold)
+	fmt.Println("------------------------")
+}
+
+func (gs *GameState) upkeep() int {
+	upkeep := 0
+	for _, unit := range gs.getUnitsSnap() {
+		rr, _ := gs.Rules.rank(unit.Rank)
+		upkeep += rr.Upkeep
+	}
+	return upkeep
+}
+
+// spend takes amount gold from the treasury, or fails without spending
+// anything if the player can not afford it. Only a spend that goes through
+// is saved.
+func (gs *GameState) spend(amount int, what string) error {
+	gs.mu.Lock()
+	if amount > gs.Treasury.Gold {
+		gold := gs.Treasury.Gold
+		gs.mu.Unlock()
+		return fmt.Errorf("error: %s costs %d gold but you only have %d", what, amount, gold)
+	}
+	gs.Treasury.Gold -= amount
+	gs.mu.Unlock()
+	gs.changed()
+	return nil
+}
+
+func (gs *GameState) getTreasury() Treasury {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Treasury
+}
+
+// ApplyTick pays every player's income and charges their upkeep the way
+// HandleTick does for a client, so the server knows what each player can
+// afford. Ticks that are not newer than the last one are ignored.
+func (w *World) ApplyTick(tick routing.EconomyTick) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.over || tick.Tick <= w.tick {
+		return
+	}
+	w.tick = tick.Tick
+
+	// Players earn from what they own even without units
+	owned := map[string]int{}
+	players := map[string]struct{}{}
+	for _, owner := range w.Owners {
+		owned[owner]++
+		players[owner] = struct{}{}
+	}
+	for username := range w.Players {
+		players[username] = struct{}{}
+	}
+	for username := range players {
+		if username == "" {
+			continue
+		}
+		t := w.treasury(username)
+		t.Gold += owned[username] * w.Rules.Economy.IncomePerLocation
+		for _, unit := range w.Players[username].Units {
+			rr, _ := w.Rules.rank(unit.Rank)
+			t.Gold -= rr.Upkeep
+		}
+		t.Gold = max(t.Gold, 0)
+		t.LastTick = tick.Tick
+		w.Treasuries[username] = t
+	}
+}
+
+// treasury returns a player's treasury, which starts with the starting gold
+// for players the server has not charged yet.
+func (w *World) treasury(username string) Treasury {
+	t, ok := w.Treasuries[username]
+	if !ok {
+		return Treasury{Gold: w.Rules.Economy.StartingGold}
+	}
+	return t
+}
+
+func (w *World) gold(username string) int {
+	return w.treasury(username).Gold
+}
+
+func (w *World) charge(username string, amount int) {
+	t := w.treasury(username)
+	t.Gold -= amount
+	w.Treasuries[username] = t
+}
@@ -3,6 +3,9 @@ package gamelogic
 type Player struct {
 	Username string
 	Units    map[int]Unit
+	// Allies are the players this player has offered an alliance to. Two
+	// players fight on the same side only if they list each other.
+	Allies []string
 }
 
 type UnitRank string
@@ -20,33 +23,26 @@ type Unit struct {
 }
 
 type ArmyMove struct {
+	ID         string
 	Player     Player
 	Units      []Unit
 	ToLocation Location
 }
 
-type RecognitionOfWar struct {
-	Attacker Player
-	Defender Player
+// Battle is declared by the server for a location where players that are
+// not allied share the ground. Every participant resolves it locally with
+// the same Seed.
+type Battle struct {
+	ID        string
+	Seed      int64
+	Location  Location
+	Aggressor string
+	Declarer  string
+	Sides     []BattleSide
 }
 
-type Location string
-
-func getAllRanks() map[UnitRank]struct{} {
-	return map[UnitRank]struct{}{
-		RankInfantry:  {},
-		RankCavalry:   {},
-		RankArtillery: {},
-	}
+type BattleSide struct {
+	Players []Player
 }
 
-func getAllLocations() map[Location]struct{} {
-	return map[Location]struct{}{
-		"americas":   {},
-		"europe":     {},
-		"africa":     {},
-		"asia":       {},
-		"australia":  {},
-		"antarctica": {},
-	}
-}
+type Location string
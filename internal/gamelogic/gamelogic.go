@@ -7,6 +7,8 @@ import (
 	"math/rand"
 	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
 func PrintClientHelp() {
@@ -17,7 +19,11 @@ func PrintClientHelp() {
 	fmt.Println("* spawn <location> <rank>")
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
+	fmt.Println("* ally <username>")
+	fmt.Println("* unally <username>")
 	fmt.Println("* status")
+	fmt.Println("* world")
+	fmt.Println("* players")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
@@ -25,37 +31,84 @@ func PrintClientHelp() {
 	fmt.Println("* help")
 }
 
-func ClientWelcome() (string, error) {
+// ClientWelcome asks for a username until the registry lets us claim one.
+func ClientWelcome(registry UsernameRegistry) (string, error) {
 	fmt.Println("Welcome to the Peril client!")
-	fmt.Println("Please enter your username:")
-	words := GetInput()
-	if len(words) == 0 {
-		return "", errors.New("you must enter a username. goodbye")
+	for {
+		fmt.Println("Please enter your username:")
+		words := GetInput()
+		if len(words) == 0 {
+			return "", errors.New("you must enter a username. goodbye")
+		}
+		username := words[0]
+		err := ValidateUsername(username)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		err = registry.Claim(username)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		fmt.Printf("Welcome, %s!\n", username)
+		PrintClientHelp()
+		return username, nil
 	}
-	username := words[0]
-	fmt.Printf("Welcome, %s!\n", username)
-	PrintClientHelp()
-	return username, nil
 }
 
 func PrintServerHelp() {
 	fmt.Println("Possible commands:")
-	fmt.Println("* pause")
+	fmt.Println("* games")
+	fmt.Println("* create <gameID>")
+	fmt.Println("* use <gameID>")
+	fmt.Println("* close <gameID>")
+	fmt.Println("* pause [duration] [reason]")
+	fmt.Println("    example:")
+	fmt.Println("    pause 10m lunch break")
+	fmt.Println("* pause at <hh:mm> [duration] [reason]")
+	fmt.Println("* pause every <hh:mm> <duration> [reason]")
+	fmt.Println("* pause cancel")
 	fmt.Println("* resume")
+	fmt.Println("* status")
+	fmt.Println("* world")
+	fmt.Println("* standings")
+	fmt.Println("* players")
+	fmt.Println("* announce <message>")
+	fmt.Println("* kick <username> [reason]")
+	fmt.Println("* ban <username> [reason]")
+	fmt.Println("* unban <username>")
+	fmt.Println("* bans")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
 
+var (
+	inputOnce  = &sync.Once{}
+	inputLines chan []string
+)
+
+// inputs returns the words of every line typed, read by a single goroutine
+// so a command can wait for input and something else at once. It is closed
+// when stdin is.
+func inputs() <-chan []string {
+	inputOnce.Do(func() {
+		inputLines = make(chan []string)
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				inputLines <- strings.Fields(line)
+			}
+			close(inputLines)
+		}()
+	})
+	return inputLines
+}
+
 func GetInput() []string {
 	fmt.Print("> ")
-	scanner := bufio.NewScanner(os.Stdin)
-	scanned := scanner.Scan()
-	if !scanned {
-		return nil
-	}
-	line := scanner.Text()
-	line = strings.TrimSpace(line)
-	return strings.Fields(line)
+	return <-inputs()
 }
 
 func GetMaliciousLog() string {
@@ -78,7 +131,14 @@ func PrintQuit() {
 
 func (gs *GameState) CommandStatus() {
 	if gs.isPaused() {
+		ps := gs.getPauseState()
 		fmt.Println("The game is paused.")
+		if ps.Reason != "" {
+			fmt.Printf("Reason: %s\n", ps.Reason)
+		}
+		if !ps.ResumeAt.IsZero() {
+			fmt.Printf("It resumes at %s.\n", ps.ResumeAt.Format(time.TimeOnly))
+		}
 		return
 	} else {
 		fmt.Println("The game is not paused.")
@@ -89,4 +149,11 @@ func (gs *GameState) CommandStatus() {
 	for _, unit := range p.Units {
 		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
 	}
+
+	treasury := gs.getTreasury()
+	controlled := gs.ownedLocations()
+	fmt.Println("Treasury:")
+	fmt.Printf("* gold: %d\n", treasury.Gold)
+	fmt.Printf("* income: %d per tick from %d location(s)\n", len(controlled)*gs.Rules.Economy.IncomePerLocation, len(controlled))
+	fmt.Printf("* upkeep: %d per tick\n", gs.upkeep())
 }
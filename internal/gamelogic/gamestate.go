@@ -2,32 +2,65 @@ package gamelogic
 
 import (
 	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
 type GameState struct {
 	Player Player
 	Paused bool
-	mu     *sync.RWMutex
+	// pauseState is the last pause, resume or warning applied
+	pauseState routing.PlayingState
+	Over       bool
+	Rules      Rules
+	Treasury   Treasury
+	// NextUnitID is the ID given to the next spawned unit. IDs are never
+	// reused, so a lost unit can not be confused with a new one.
+	NextUnitID int
+	// others holds the last known snapshot of every other player, taken from
+	// their moves.
+	others map[string]Player
+	owners map[Location]string
+	fought map[string]struct{}
+	roster []PlayerPresence
+	// lastMove is what CancelMove needs to take back the last move made
+	lastMove pendingMove
+	mu       *sync.RWMutex
+
+	saveGameID string
+	savePath   string
+	saveMu     *sync.Mutex
 }
 
-func NewGameState(username string) *GameState {
+func NewGameState(username string, rules Rules) *GameState {
 	return &GameState{
 		Player: Player{
 			Username: username,
 			Units:    map[int]Unit{},
 		},
 		Paused: false,
-		mu:     &sync.RWMutex{},
+		Rules:  rules,
+		Treasury: Treasury{
+			Gold: rules.Economy.StartingGold,
+		},
+		NextUnitID: 1,
+		others:     map[string]Player{},
+		owners:     map[Location]string{},
+		fought:     map[string]struct{}{},
+		mu:         &sync.RWMutex{},
+		saveMu:     &sync.Mutex{},
 	}
 }
 
 func (gs *GameState) resumeGame() {
+	defer gs.changed()
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 	gs.Paused = false
 }
 
 func (gs *GameState) pauseGame() {
+	defer gs.changed()
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 	gs.Paused = true
@@ -40,22 +73,81 @@ func (gs *GameState) isPaused() bool {
 }
 
 func (gs *GameState) addUnit(u Unit) {
+	defer gs.changed()
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 	gs.Player.Units[u.ID] = u
 }
 
-func (gs *GameState) removeUnitsInLocation(loc Location) {
+func (gs *GameState) nextUnitID() int {
+	defer gs.changed()
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	for k, v := range gs.Player.Units {
-		if v.Location == loc {
-			delete(gs.Player.Units, k)
+	id := gs.NextUnitID
+	gs.NextUnitID++
+	return id
+}
+
+// removeUnits deletes the given units, skipping any that have since moved
+// away from where they were lost.
+func (gs *GameState) removeUnits(units []Unit) {
+	defer gs.changed()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for _, u := range units {
+		if current, ok := gs.Player.Units[u.ID]; ok && current.Location == u.Location {
+			delete(gs.Player.Units, u.ID)
 		}
 	}
 }
 
+func (gs *GameState) rememberPlayer(p Player) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.others[p.Username] = p
+}
+
+func (gs *GameState) forgetUnits(username string, units []Unit) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	p, ok := gs.others[username]
+	if !ok {
+		return
+	}
+	remaining := map[int]Unit{}
+	for k, v := range p.Units {
+		remaining[k] = v
+	}
+	for _, u := range units {
+		delete(remaining, u.ID)
+	}
+	p.Units = remaining
+	gs.others[username] = p
+}
+
+func (gs *GameState) getOthersSnap() []Player {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	others := []Player{}
+	for _, p := range gs.others {
+		others = append(others, p)
+	}
+	return others
+}
+
+// markFought records a battle as resolved and reports whether it was new.
+func (gs *GameState) markFought(id string) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if _, ok := gs.fought[id]; ok {
+		return false
+	}
+	gs.fought[id] = struct{}{}
+	return true
+}
+
 func (gs *GameState) UpdateUnit(u Unit) {
+	defer gs.changed()
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
 	gs.Player.Units[u.ID] = u
@@ -92,5 +184,6 @@ func (gs *GameState) GetPlayerSnap() Player {
 	return Player{
 		Username: gs.Player.Username,
 		Units:    Units,
+		Allies:   append([]string{}, gs.Player.Allies...),
 	}
 }
@@ -0,0 +1,370 @@
+package gamelogic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type WorldEventKind string
+
+const (
+	EventCreate     WorldEventKind = "create"
+	EventMatchStart WorldEventKind = "match_start"
+	EventSpawn      WorldEventKind = "spawn"
+	EventMove       WorldEventKind = "move"
+	EventBattle     WorldEventKind = "battle"
+	EventPause      WorldEventKind = "pause"
+	EventGameOver   WorldEventKind = "game_over"
+	EventRecover    WorldEventKind = "recover"
+	EventTick       WorldEventKind = "tick"
+)
+
+// ErrRejected is returned by Record for a spawn or move that breaks the
+// rules or that the player can not pay for. Nothing is recorded for it.
+var ErrRejected = errors.New("event rejected")
+
+// Recovery is recorded when the server rebuilds a game after being down. The
+// game clock stops while the server is down, so a time limit is not used up
+// by the outage.
+type Recovery struct {
+	Downtime time.Duration
+}
+
+// WorldEvent is one change to a game's world, as the server applied it.
+// Only the field for its kind is set. A battle carries the report the
+// server resolved, so replaying it does not depend on the combat rules of
+// the replaying build.
+type WorldEvent struct {
+	Seq      int64
+	GameID   string
+	At       time.Time
+	Kind     WorldEventKind
+	Rules    *Rules                `json:",omitempty"`
+	Start    *MatchStart           `json:",omitempty"`
+	Spawn    *UnitSpawn            `json:",omitempty"`
+	Move     *ArmyMove             `json:",omitempty"`
+	Battle   *BattleReport         `json:",omitempty"`
+	Pause    *routing.PlayingState `json:",omitempty"`
+	GameOver *GameOver             `json:",omitempty"`
+	Recover  *Recovery             `json:",omitempty"`
+	Tick     *routing.EconomyTick  `json:",omitempty"`
+}
+
+// Apply is the reducer that moves the world on by one event. Replaying a
+// game's events in order from its create event rebuilds its world.
+func (w *World) Apply(ev WorldEvent) []OwnershipChange {
+	switch ev.Kind {
+	case EventCreate:
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if ev.Rules != nil {
+			w.Rules = *ev.Rules
+		}
+		w.CreatedAt = ev.At
+		w.StartedAt = ev.At
+	case EventMatchStart:
+		return w.StartMatch(*ev.Start, ev.At)
+	case EventSpawn:
+		return w.ApplySpawn(*ev.Spawn)
+	case EventMove:
+		return w.ApplyMove(*ev.Move)
+	case EventBattle:
+		return w.ApplyBattle(*ev.Battle)
+	case EventPause:
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.Pause = *ev.Pause
+	case EventGameOver:
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.over = true
+	case EventRecover:
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.StartedAt = w.StartedAt.Add(ev.Recover.Downtime)
+	case EventTick:
+		w.ApplyTick(*ev.Tick)
+	}
+	return nil
+}
+
+// check reports why a spawn or move can not be applied to the world. Other
+// events come from the server and are always applied.
+func (w *World) check(ev WorldEvent) error {
+	switch ev.Kind {
+	case EventSpawn:
+		return w.checkSpawn(*ev.Spawn)
+	case EventMove:
+		return w.checkMove(*ev.Move)
+	}
+	return nil
+}
+
+// complete reports whether the field for the event's kind is set.
+func (ev WorldEvent) complete() bool {
+	switch ev.Kind {
+	case EventCreate:
+		return ev.Rules != nil
+	case EventMatchStart:
+		return ev.Start != nil
+	case EventSpawn:
+		return ev.Spawn != nil
+	case EventMove:
+		return ev.Move != nil
+	case EventBattle:
+		return ev.Battle != nil
+	case EventPause:
+		return ev.Pause != nil
+	case EventGameOver:
+		return ev.GameOver != nil
+	case EventRecover:
+		return ev.Recover != nil
+	case EventTick:
+		return ev.Tick != nil
+	default:
+		return false
+	}
+}
+
+// ReplayWorld rebuilds a world from its events, stopping before the first
+// event stop returns true for. The first event must be the game's create
+// event.
+func ReplayWorld(events []WorldEvent, stop func(WorldEvent) bool) (*World, error) {
+	if len(events) == 0 || events[0].Kind != EventCreate || events[0].Rules == nil {
+		return nil, errors.New("events do not start with the game being created")
+	}
+	w := NewWorld(*events[0].Rules)
+	for _, ev := range events {
+		if stop != nil && stop(ev) {
+			break
+		}
+		w.Apply(ev)
+	}
+	return w, nil
+}
+
+// DefaultEventsDir is where the server keeps the event logs of its games.
+const DefaultEventsDir = "logs/events"
+
+// EventLog is the ordered, append-only log of a game's world events. The
+// server records every event before applying it, so the world can be
+// rebuilt after a crash.
+type EventLog struct {
+	gameID string
+	path   string
+	file   *os.File
+	size   int64
+	seq    int64
+	mu     *sync.Mutex
+}
+
+// EventLogPath is where a game's event log lives in dir.
+func EventLogPath(dir, gameID string) string {
+	return filepath.Join(dir, gameID+".events.jsonl")
+}
+
+// OpenEventLog opens the event log of a game at path for appending and
+// returns the events already in it. A last line cut short by a crash is
+// dropped.
+func OpenEventLog(path, gameID string) (*EventLog, []WorldEvent, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create events directory: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open event log: %v", err)
+	}
+	events, size, err := readEvents(f)
+	if err == nil {
+		err = f.Truncate(size)
+	}
+	if err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("could not read event log: %v", err)
+	}
+
+	l := &EventLog{
+		gameID: gameID,
+		path:   path,
+		file:   f,
+		size:   size,
+		mu:     &sync.Mutex{},
+	}
+	if len(events) > 0 {
+		l.seq = events[len(events)-1].Seq
+	}
+	return l, events, nil
+}
+
+// ReadEvents reads every complete event in the event log at path.
+func ReadEvents(path string) ([]WorldEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	events, _, err := readEvents(f)
+	return events, err
+}
+
+// readEvents returns the events in r and how many bytes of complete lines
+// they were read from.
+func readEvents(r io.Reader) ([]WorldEvent, int64, error) {
+	events := []WorldEvent{}
+	var size int64
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return events, size, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		size += int64(len(line))
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var ev WorldEvent
+		err = json.Unmarshal(line, &ev)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed JSON unmarshal event %d: %v", len(events)+1, err)
+		}
+		if !ev.complete() {
+			return nil, 0, fmt.Errorf("event %d is a %s without its details", ev.Seq, ev.Kind)
+		}
+		events = append(events, ev)
+	}
+}
+
+// Record numbers an event, appends it to the log, syncs it to disk and only
+// then applies it to the world. Events are recorded one at a time, so the
+// log is in the order the world changed. A spawn or move is checked against
+// the world first and fails with ErrRejected if it is not allowed.
+func (l *EventLog) Record(w *World, ev WorldEvent) (WorldEvent, []OwnershipChange, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.record(w, ev)
+}
+
+// RecordVictory checks the world's victory conditions and records the game
+// over if one is met, with no other event recorded in between. The game is
+// only over once the event is written, so if writing it fails the game
+// carries on, in memory as after a restart, and the next check tries again.
+func (l *EventLog) RecordVictory(w *World, now time.Time) (GameOver, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	over, ok := w.CheckVictory(now)
+	if !ok {
+		return over, false, nil
+	}
+	_, _, err := l.record(w, WorldEvent{
+		At:       now,
+		Kind:     EventGameOver,
+		GameOver: &over,
+	})
+	return over, true, err
+}
+
+func (l *EventLog) record(w *World, ev WorldEvent) (WorldEvent, []OwnershipChange, error) {
+	err := w.check(ev)
+	if err != nil {
+		return ev, nil, fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	ev.Seq = l.seq + 1
+	ev.GameID = l.gameID
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return ev, nil, fmt.Errorf("failed JSON marshal event: %v", err)
+	}
+	line = append(line, '\n')
+	_, err = l.file.Write(line)
+	if err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		// Drop whatever part of the event was written, so the next one
+		// starts on its own line
+		l.file.Truncate(l.size)
+		l.file.Seek(l.size, io.SeekStart)
+		return ev, nil, fmt.Errorf("could not write event log: %v", err)
+	}
+	l.size += int64(len(line))
+	l.seq = ev.Seq
+	return ev, w.Apply(ev), nil
+}
+
+func (l *EventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Archive closes the log and moves it aside, stamped with now, so the game
+// ID can be used again while its history is kept for replays.
+func (l *EventLog) Archive(now time.Time) (string, error) {
+	err := l.Close()
+	if err != nil {
+		return "", err
+	}
+	return ArchiveEventLog(l.path, now)
+}
+
+// ArchiveEventLog moves the event log at path aside, stamped with now.
+func ArchiveEventLog(path string, now time.Time) (string, error) {
+	archived := strings.TrimSuffix(path, ".events.jsonl") + "-" + now.Format(backupTimeFormat) + ".events.jsonl"
+	return archived, os.Rename(path, archived)
+}
+
+func (ev WorldEvent) String() string {
+	var what string
+	switch ev.Kind {
+	case EventCreate:
+		what = fmt.Sprintf("game %s was created", ev.GameID)
+	case EventMatchStart:
+		what = fmt.Sprintf("the match started with %d player(s)", len(ev.Start.StartingLocations))
+	case EventSpawn:
+		what = fmt.Sprintf("%s spawned %s #%d in %s", ev.Spawn.Player.Username, ev.Spawn.Unit.Rank, ev.Spawn.Unit.ID, ev.Spawn.Unit.Location)
+	case EventMove:
+		what = fmt.Sprintf("%s moved %d unit(s) to %s", ev.Move.Player.Username, len(ev.Move.Units), ev.Move.ToLocation)
+	case EventBattle:
+		what = ev.Battle.Summary()
+	case EventPause:
+		switch {
+		case !ev.Pause.PausingAt.IsZero():
+			what = fmt.Sprintf("a pause was scheduled for %s: %s", ev.Pause.PausingAt.Format(time.TimeOnly), ev.Pause.Reason)
+		case ev.Pause.IsPaused:
+			what = "the game was paused: " + ev.Pause.Reason
+		default:
+			what = "the game was resumed: " + ev.Pause.Reason
+		}
+	case EventGameOver:
+		what = ev.GameOver.Summary()
+	case EventTick:
+		what = fmt.Sprintf("economy tick %d", ev.Tick.Tick)
+	case EventRecover:
+		what = fmt.Sprintf("the server recovered the game after %v down", ev.Recover.Downtime.Round(time.Second))
+	default:
+		what = string(ev.Kind)
+	}
+	return fmt.Sprintf("#%d %s %s", ev.Seq, ev.At.Local().Format(time.DateTime), what)
+}
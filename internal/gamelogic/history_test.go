@@ -0,0 +1,219 @@
+package gamelogic
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+var historyStart = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// testHistory is a short game: alice and bob start, alice moves into bob's
+// location and wins the battle there, then the game is paused and ends.
+func testHistory() []WorldEvent {
+	rules := DefaultRules()
+	rules.Victory.TimeLimitSeconds = 600
+
+	alice := Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "americas"},
+	}}
+	bob := Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		2: {ID: 2, Rank: RankInfantry, Location: "europe"},
+	}}
+	moved := Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+	}}
+	battle := BattleReport{
+		BattleID: "europe-alice-m1",
+		Location: "europe",
+		Sides: []SideReport{
+			{Players: []string{"alice"}, Survivors: []BattleUnit{{Owner: "alice", Unit: moved.Units[1]}}},
+			{Players: []string{"bob"}, Defending: true, Casualties: []BattleUnit{
+				{Owner: "bob", Unit: bob.Units[1]},
+				{Owner: "bob", Unit: bob.Units[2]},
+			}},
+		},
+		Winners: []string{"alice"},
+	}
+
+	events := []WorldEvent{
+		{Kind: EventCreate, Rules: &rules},
+		{Kind: EventMatchStart, Start: &MatchStart{
+			GameID:            "test",
+			StartingLocations: map[string]Location{"alice": "americas", "bob": "europe"},
+		}},
+		{Kind: EventSpawn, Spawn: &UnitSpawn{Player: alice, Unit: alice.Units[1]}},
+		{Kind: EventSpawn, Spawn: &UnitSpawn{Player: bob, Unit: bob.Units[1]}},
+		{Kind: EventSpawn, Spawn: &UnitSpawn{Player: bob, Unit: bob.Units[2]}},
+		{Kind: EventMove, Move: &ArmyMove{ID: "m1", Player: moved, Units: []Unit{moved.Units[1]}, ToLocation: "europe"}},
+		{Kind: EventBattle, Battle: &battle},
+		{Kind: EventBattle, Battle: &battle},
+		{Kind: EventPause, Pause: &routing.PlayingState{IsPaused: true, Reason: "lunch", Version: 1}},
+		{Kind: EventGameOver, GameOver: &GameOver{Winner: "alice", Reason: "by eliminating all opponents"}},
+	}
+	for i := range events {
+		events[i].Seq = int64(i + 1)
+		events[i].GameID = "test"
+		events[i].At = historyStart.Add(time.Duration(i) * time.Minute)
+	}
+	return events
+}
+
+func TestReplayWorld(t *testing.T) {
+	events := testHistory()
+	w, err := ReplayWorld(events, nil)
+	if err != nil {
+		t.Fatalf("ReplayWorld: %v", err)
+	}
+
+	if got := w.Owners["americas"]; got != "alice" {
+		t.Errorf("americas is owned by %q, want alice", got)
+	}
+	if got := w.Owners["europe"]; got != "alice" {
+		t.Errorf("europe is owned by %q, want alice", got)
+	}
+	if got := len(w.Players["bob"].Units); got != 0 {
+		t.Errorf("bob has %d units, want 0", got)
+	}
+	if got := len(w.Players["alice"].Units); got != 1 {
+		t.Errorf("alice has %d units, want 1", got)
+	}
+	if !w.StartedAt.Equal(events[1].At) {
+		t.Errorf("started at %v, want the match start at %v", w.StartedAt, events[1].At)
+	}
+	if !w.Pause.IsPaused || w.Pause.Reason != "lunch" {
+		t.Errorf("pause state is %+v, want paused for lunch", w.Pause)
+	}
+	if !w.IsOver() {
+		t.Error("the game is not over")
+	}
+}
+
+func TestReplayWorldStop(t *testing.T) {
+	events := testHistory()
+	w, err := ReplayWorld(events, func(ev WorldEvent) bool {
+		return ev.Kind == EventBattle
+	})
+	if err != nil {
+		t.Fatalf("ReplayWorld: %v", err)
+	}
+
+	// Before the battle europe is contested and keeps its owner
+	if got := w.Owners["europe"]; got != "bob" {
+		t.Errorf("europe is owned by %q, want bob", got)
+	}
+	if got := len(w.Players["bob"].Units); got != 2 {
+		t.Errorf("bob has %d units, want 2", got)
+	}
+	if w.IsOver() {
+		t.Error("the game is over before its game over event")
+	}
+}
+
+func TestReplayWorldNeedsCreate(t *testing.T) {
+	events := testHistory()
+	tests := []struct {
+		name   string
+		events []WorldEvent
+	}{
+		{"no events", nil},
+		{"no create event", events[1:]},
+		{"create without rules", []WorldEvent{{Kind: EventCreate}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReplayWorld(tt.events, nil)
+			if err == nil {
+				t.Error("ReplayWorld succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestApplyDuplicateBattle(t *testing.T) {
+	events := testHistory()
+	w, err := ReplayWorld(events[:7], nil)
+	if err != nil {
+		t.Fatalf("ReplayWorld: %v", err)
+	}
+	changes := w.Apply(events[7])
+	if len(changes) != 0 {
+		t.Errorf("applying a battle twice changed %v", changes)
+	}
+	if got := len(w.Players["alice"].Units); got != 1 {
+		t.Errorf("alice has %d units, want 1", got)
+	}
+}
+
+func TestApplyAfterGameOver(t *testing.T) {
+	w, err := ReplayWorld(testHistory(), nil)
+	if err != nil {
+		t.Fatalf("ReplayWorld: %v", err)
+	}
+	carol := Player{Username: "carol", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "asia"},
+	}}
+	w.Apply(WorldEvent{Kind: EventSpawn, Spawn: &UnitSpawn{Player: carol, Unit: carol.Units[1]}})
+	if _, ok := w.Players["carol"]; ok {
+		t.Error("a spawn was applied after the game ended")
+	}
+}
+
+func TestApplyRecover(t *testing.T) {
+	events := testHistory()
+	w, err := ReplayWorld(events[:6], nil)
+	if err != nil {
+		t.Fatalf("ReplayWorld: %v", err)
+	}
+	// The server was down for an hour, well past the 10 minute time limit
+	w.Apply(WorldEvent{
+		Kind:    EventRecover,
+		At:      events[5].At.Add(time.Hour),
+		Recover: &Recovery{Downtime: time.Hour},
+	})
+
+	want := events[1].At.Add(time.Hour)
+	if !w.StartedAt.Equal(want) {
+		t.Errorf("started at %v, want %v", w.StartedAt, want)
+	}
+	if over, ok := w.CheckVictory(events[5].At.Add(time.Hour)); ok {
+		t.Errorf("the game ended on recovery: %v", over.Summary())
+	}
+	if _, ok := w.CheckVictory(want.Add(10 * time.Minute)); !ok {
+		t.Error("the time limit did not end the game")
+	}
+}
+
+func TestRecordVictory(t *testing.T) {
+	events := testHistory()
+	// After the battle bob has nothing left
+	w, err := ReplayWorld(events[:7], nil)
+	if err != nil {
+		t.Fatalf("ReplayWorld: %v", err)
+	}
+	if _, ok := w.CheckVictory(events[6].At); !ok {
+		t.Fatal("CheckVictory found no winner")
+	}
+	if w.IsOver() {
+		t.Fatal("CheckVictory ended the game before it was recorded")
+	}
+
+	l, _, err := OpenEventLog(filepath.Join(t.TempDir(), "test.events.jsonl"), "test")
+	if err != nil {
+		t.Fatalf("OpenEventLog: %v", err)
+	}
+	defer l.Close()
+	over, ok, err := l.RecordVictory(w, events[6].At)
+	if !ok || err != nil {
+		t.Fatalf("RecordVictory returned %v, %v", ok, err)
+	}
+	if over.Winner != "alice" {
+		t.Errorf("%q won, want alice", over.Winner)
+	}
+	if !w.IsOver() {
+		t.Error("the game is not over once its game over was recorded")
+	}
+}
@@ -0,0 +1,74 @@
+package gamelogic
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadKey reads an Ed25519 signing key, creating it if there is none, so
+// the same key is used across restarts. Its public key is written next to
+// it in path.pub for whoever checks the signatures.
+func LoadKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("%s is not a signing key", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(path, []byte(hex.EncodeToString(private.Seed())+"\n"), 0o600)
+	if err != nil {
+		return nil, err
+	}
+	err = SavePublicKey(path+".pub", public)
+	if err != nil {
+		return nil, err
+	}
+	return private, nil
+}
+
+// LoadPublicKey reads a public key written by LoadKey or SavePublicKey.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s is not a public key", path)
+	}
+	return key, nil
+}
+
+func SavePublicKey(path string, key ed25519.PublicKey) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o644)
+}
+
+// KeyPath is where a player's signing key lives unless another path is
+// given.
+func KeyPath(username string) string {
+	return fmt.Sprintf("peril-%s.key", username)
+}
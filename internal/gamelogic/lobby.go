@@ -0,0 +1,425 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type LobbyAction string
+
+const (
+	LobbyActionList    LobbyAction = "list"
+	LobbyActionCreate  LobbyAction = "create"
+	LobbyActionJoin    LobbyAction = "join"
+	LobbyActionLeave   LobbyAction = "leave"
+	LobbyActionReady   LobbyAction = "ready"
+	LobbyActionUnready LobbyAction = "unready"
+)
+
+// ReadyTimeout is how long a ready player waits for the match to start
+// before they are taken back to the lobby.
+const ReadyTimeout = 10 * time.Minute
+
+// startTimeout is how long a player whose game has started waits for its
+// match start to arrive.
+const startTimeout = time.Minute
+
+// LobbyRequest is sent by clients to the server's lobby over request/reply.
+type LobbyRequest struct {
+	Action   LobbyAction
+	GameID   string
+	Username string
+}
+
+// LobbyResponse is the server's reply. Error is empty on success. A request
+// that failed because its game has started still carries the game.
+type LobbyResponse struct {
+	Error string
+	Games []LobbyGame
+	Game  LobbyGame
+}
+
+type LobbyGame struct {
+	ID         string
+	Players    []string
+	Ready      []string
+	MinPlayers int
+	MaxPlayers int
+	Started    bool
+}
+
+// MatchStart is broadcast to a game's players when enough of them are ready.
+type MatchStart struct {
+	GameID            string
+	StartingLocations map[string]Location
+}
+
+// Lobby keeps track of the players waiting in each open game.
+type Lobby struct {
+	games map[string]*LobbyGame
+	mu    *sync.Mutex
+}
+
+func NewLobby() *Lobby {
+	return &Lobby{
+		games: map[string]*LobbyGame{},
+		mu:    &sync.Mutex{},
+	}
+}
+
+func (l *Lobby) Open(id string, rules Rules) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.games[id] = &LobbyGame{
+		ID:         id,
+		MinPlayers: rules.minPlayers(),
+		MaxPlayers: rules.Lobby.MaxPlayers,
+	}
+}
+
+func (l *Lobby) Close(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.games, id)
+}
+
+// List returns every game that has not started yet.
+func (l *Lobby) List() []LobbyGame {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	games := []LobbyGame{}
+	for _, g := range l.games {
+		if !g.Started {
+			games = append(games, g.snapshot())
+		}
+	}
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].ID < games[j].ID
+	})
+	return games
+}
+
+func (l *Lobby) Join(id, username string) (LobbyGame, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g, err := l.open(id)
+	if err != nil {
+		return LobbyGame{}, err
+	}
+	if lists(g.Players, username) {
+		return g.snapshot(), nil
+	}
+	if g.MaxPlayers > 0 && len(g.Players) >= g.MaxPlayers {
+		return LobbyGame{}, fmt.Errorf("game %s is full", id)
+	}
+	g.Players = append(g.Players, username)
+	return g.snapshot(), nil
+}
+
+func (l *Lobby) Leave(id, username string) (LobbyGame, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g, err := l.open(id)
+	if err != nil {
+		return l.started(id), err
+	}
+	g.Players = without(g.Players, username)
+	g.Ready = without(g.Ready, username)
+	return g.snapshot(), nil
+}
+
+// Ready marks a player as ready. It reports whether the game should start,
+// which happens once at least MinPlayers have joined and all of them are
+// ready. A started game is closed to new players.
+func (l *Lobby) Ready(id, username string) (LobbyGame, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g, err := l.open(id)
+	if err != nil {
+		return LobbyGame{}, false, err
+	}
+	if !lists(g.Players, username) {
+		return LobbyGame{}, false, fmt.Errorf("you have not joined game %s", id)
+	}
+	if !lists(g.Ready, username) {
+		g.Ready = append(g.Ready, username)
+	}
+	if len(g.Players) >= g.MinPlayers && len(g.Ready) == len(g.Players) {
+		g.Started = true
+		return g.snapshot(), true, nil
+	}
+	return g.snapshot(), false, nil
+}
+
+// Unready takes back a player's ready, such as when they got tired of
+// waiting.
+func (l *Lobby) Unready(id, username string) (LobbyGame, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g, err := l.open(id)
+	if err != nil {
+		return l.started(id), err
+	}
+	g.Ready = without(g.Ready, username)
+	return g.snapshot(), nil
+}
+
+func (l *Lobby) open(id string) (*LobbyGame, error) {
+	g, ok := l.games[id]
+	if !ok {
+		return nil, fmt.Errorf("no game %s", id)
+	}
+	if g.Started {
+		return nil, fmt.Errorf("game %s has already started", id)
+	}
+	return g, nil
+}
+
+// started returns the game if it has started, so a player backing out of it
+// can tell they are in the match after all.
+func (l *Lobby) started(id string) LobbyGame {
+	g, ok := l.games[id]
+	if !ok || !g.Started {
+		return LobbyGame{}
+	}
+	return g.snapshot()
+}
+
+func (g *LobbyGame) snapshot() LobbyGame {
+	return LobbyGame{
+		ID:         g.ID,
+		Players:    append([]string{}, g.Players...),
+		Ready:      append([]string{}, g.Ready...),
+		MinPlayers: g.MinPlayers,
+		MaxPlayers: g.MaxPlayers,
+		Started:    g.Started,
+	}
+}
+
+func without(names []string, name string) []string {
+	result := []string{}
+	for _, n := range names {
+		if n != name {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// AssignStartingLocations gives each player a different location, spreading
+// them across the map in rules order. Players are assigned in name order so
+// every server agrees.
+func AssignStartingLocations(rules Rules, players []string) (map[string]Location, error) {
+	if len(players) > len(rules.Locations) {
+		return nil, errors.New("there are more players than locations")
+	}
+	sorted := append([]string{}, players...)
+	sort.Strings(sorted)
+
+	step := len(rules.Locations) / max(len(sorted), 1)
+	starts := map[string]Location{}
+	for i, username := range sorted {
+		starts[username] = rules.Locations[i*step].Name
+	}
+	return starts, nil
+}
+
+func (g LobbyGame) String() string {
+	return fmt.Sprintf("%s: %d/%d player(s) ready, %d needed %v", g.ID, len(g.Ready), len(g.Players), g.MinPlayers, g.Players)
+}
+
+// LobbyClient is how ClientLobby talks to the server's lobby.
+type LobbyClient interface {
+	Send(req LobbyRequest) (LobbyResponse, error)
+	// AwaitStart starts listening for a game's match start. It is called
+	// before the player is marked ready so the start can not be missed, and
+	// again each time they ready up for the same game.
+	AwaitStart(gameID string) (<-chan MatchStart, error)
+}
+
+func PrintLobbyHelp() {
+	fmt.Println("Lobby commands:")
+	fmt.Println("* list")
+	fmt.Println("* create <gameID>")
+	fmt.Println("* join <gameID>")
+	fmt.Println("* leave")
+	fmt.Println("* ready")
+	fmt.Println("* unready (while waiting for the match)")
+	fmt.Println("* help")
+}
+
+// ClientLobby lets the player list, create and join games, then waits for
+// the match to start once they are ready.
+func ClientLobby(username string, client LobbyClient) (MatchStart, error) {
+	PrintLobbyHelp()
+	joined := ""
+	for {
+		words := GetInput()
+		if len(words) == 0 {
+			continue
+		}
+
+		req := LobbyRequest{Username: username, GameID: joined}
+		switch words[0] {
+		case "list":
+			req.Action = LobbyActionList
+		case "create", "join":
+			if len(words) < 2 {
+				fmt.Printf("usage: %s <gameID>\n", words[0])
+				continue
+			}
+			if joined != "" {
+				fmt.Printf("You are already in game %s, leave it first.\n", joined)
+				continue
+			}
+			req.Action = LobbyAction(words[0])
+			req.GameID = words[1]
+		case "leave":
+			if joined == "" {
+				fmt.Println("You have not joined a game.")
+				continue
+			}
+			req.Action = LobbyActionLeave
+		case "ready":
+			if joined == "" {
+				fmt.Println("You have not joined a game.")
+				continue
+			}
+			start, started, err := readyUp(username, &joined, client)
+			if err != nil || started {
+				return start, err
+			}
+			continue
+		case "help":
+			PrintLobbyHelp()
+			continue
+		default:
+			fmt.Printf("Invalid command: %s.\n", words[0])
+			continue
+		}
+
+		resp, err := client.Send(req)
+		if err != nil {
+			return MatchStart{}, err
+		}
+		if resp.Error != "" {
+			fmt.Printf("Error: %s\n", resp.Error)
+			continue
+		}
+
+		switch req.Action {
+		case LobbyActionList:
+			if len(resp.Games) == 0 {
+				fmt.Println("There are no open games, create one!")
+			}
+			for _, g := range resp.Games {
+				fmt.Printf("* %v\n", g)
+			}
+		case LobbyActionCreate, LobbyActionJoin:
+			joined = resp.Game.ID
+			fmt.Printf("You joined %v\n", resp.Game)
+			fmt.Println("Type `ready` when you are ready to play.")
+		case LobbyActionLeave:
+			fmt.Printf("You left game %s.\n", joined)
+			joined = ""
+		}
+	}
+}
+
+// readyUp marks the player ready for the game they joined and waits for the
+// match to start. It reports false if the player stopped waiting, by typing
+// unready or leave or after ReadyTimeout, and they are back in the lobby.
+func readyUp(username string, joined *string, client LobbyClient) (MatchStart, bool, error) {
+	gameID := *joined
+	starts, err := client.AwaitStart(gameID)
+	if err != nil {
+		return MatchStart{}, false, err
+	}
+	resp, err := client.Send(LobbyRequest{
+		Action:   LobbyActionReady,
+		GameID:   gameID,
+		Username: username,
+	})
+	if err != nil {
+		return MatchStart{}, false, err
+	}
+	if resp.Error != "" {
+		// The game may be full, closed or have started without us
+		fmt.Printf("Error: %s\n", resp.Error)
+		return MatchStart{}, false, nil
+	}
+	fmt.Printf("You are ready! Waiting for the other players... (%v)\n", resp.Game)
+	fmt.Println("Type `unready` to stop waiting or `leave` to leave the game.")
+
+	timeout := time.NewTimer(ReadyTimeout)
+	defer timeout.Stop()
+	action := LobbyActionUnready
+	for {
+		select {
+		case start, ok := <-starts:
+			if !ok {
+				return MatchStart{}, false, errors.New("stopped waiting for the match to start")
+			}
+			return matchStarted(username, start)
+		case words, ok := <-inputs():
+			if !ok {
+				return MatchStart{}, false, errors.New("stopped waiting for the match to start")
+			}
+			if len(words) == 0 || words[0] != "unready" && words[0] != "leave" {
+				fmt.Println("Waiting for the match to start, type `unready` or `leave` to stop.")
+				continue
+			}
+			action = LobbyAction(words[0])
+		case <-timeout.C:
+			fmt.Printf("The match has not started after %v.\n", ReadyTimeout)
+		}
+		break
+	}
+
+	resp, err = client.Send(LobbyRequest{
+		Action:   action,
+		GameID:   gameID,
+		Username: username,
+	})
+	if err != nil {
+		return MatchStart{}, false, err
+	}
+	if resp.Error != "" && resp.Game.Started {
+		// The match started before our request arrived, so we are in it
+		fmt.Println("The match started before you stopped waiting.")
+		select {
+		case start, ok := <-starts:
+			if ok {
+				return matchStarted(username, start)
+			}
+		case <-time.After(startTimeout):
+		}
+		return MatchStart{}, false, fmt.Errorf("game %s started but its match start never arrived", gameID)
+	}
+	if resp.Error != "" {
+		fmt.Printf("Error: %s\n", resp.Error)
+	}
+	if action == LobbyActionLeave {
+		fmt.Printf("You left game %s.\n", gameID)
+		*joined = ""
+	} else {
+		fmt.Println("You are no longer ready. Type `ready` when you are.")
+	}
+	return MatchStart{}, false, nil
+}
+
+func matchStarted(username string, start MatchStart) (MatchStart, bool, error) {
+	fmt.Printf("The match has started! Your starting location is %s.\n", start.StartingLocations[username])
+	return start, true, nil
+}
+
+func (gs *GameState) HandleMatchStart(start MatchStart) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for username, loc := range start.StartingLocations {
+		gs.owners[loc] = username
+	}
+}
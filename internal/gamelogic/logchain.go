@@ -0,0 +1,229 @@
+package gamelogic
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// Every JSON record carries its sequence number, the hash of the record
+// before it and its own hash, so editing, removing or reordering a record
+// breaks the chain. Checkpoints sign the hash of the latest record, which
+// covers every record before it.
+
+// hashRecord is the hash of a record as it is written with its own hash
+// and signature left out. A record read from a log is hashed from the line
+// it was read from, so records written before a field was added to
+// LogRecord still hash the same.
+func hashRecord(r LogRecord) (string, error) {
+	var data []byte
+	if r.raw != "" {
+		line := strings.Replace(r.raw, `,"Hash":"`+r.Hash+`"`, `,"Hash":""`, 1)
+		line = strings.Replace(line, `,"Signature":"`+r.Signature+`"`, "", 1)
+		data = []byte(line)
+	} else {
+		r.Hash = ""
+		r.Signature = ""
+		var err error
+		data, err = json.Marshal(r)
+		if err != nil {
+			return "", fmt.Errorf("failed JSON marshal game log: %v", err)
+		}
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// chain links a record to the last one written.
+func (w *LogWriter) chain(r *LogRecord) error {
+	r.Seq = w.seq + 1
+	r.PrevHash = w.lastHash
+	hash, err := hashRecord(*r)
+	if err != nil {
+		return err
+	}
+	r.Hash = hash
+	w.seq = r.Seq
+	w.lastHash = hash
+	return nil
+}
+
+// checkpointDue reports whether a checkpoint should follow the records
+// just chained. final asks for one as long as any record is uncovered.
+func (w *LogWriter) checkpointDue(now time.Time, final bool) bool {
+	if w.cfg.Signer == nil || w.uncheckpointed == 0 {
+		return false
+	}
+	return final || now.Sub(w.lastCheckpoint) >= w.cfg.CheckpointEvery
+}
+
+// checkpoint is a signed record covering every record before it.
+func (w *LogWriter) checkpoint(now time.Time) (LogRecord, error) {
+	r := LogRecord{
+		Time:       now,
+		ReceivedAt: now,
+		Event:      routing.LogEventCheckpoint,
+		Server:     w.cfg.Instance,
+		Username:   ServerName,
+		Message:    fmt.Sprintf("checkpoint after %d entries", w.seq),
+		Signer:     hex.EncodeToString(w.cfg.Signer.Public().(ed25519.PublicKey)),
+	}
+	err := w.chain(&r)
+	if err != nil {
+		return r, err
+	}
+	r.Signature = hex.EncodeToString(ed25519.Sign(w.cfg.Signer, []byte(r.Hash)))
+	w.lastCheckpoint = now
+	w.uncheckpointed = 0
+	return r, nil
+}
+
+// resumeChain picks the chain up from the last record of the log, so it
+// carries on over restarts and rotations.
+func (w *LogWriter) resumeChain() error {
+	files, err := LogFiles(w.cfg.Path)
+	if err != nil {
+		return err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		var last LogRecord
+		_, err := ReadLogFile(files[i], 0, func(r LogRecord) {
+			if r.Hash != "" {
+				last = r
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if last.Hash != "" {
+			w.seq = last.Seq
+			w.lastHash = last.Hash
+			return nil
+		}
+	}
+	return nil
+}
+
+// LogVerification is what VerifyLog found. The log is intact if there are
+// no problems, but records after the last checkpoint are only covered by
+// the chain, so removing them from the end of the log goes unnoticed.
+type LogVerification struct {
+	Records     int
+	Checkpoints int
+	// Unchained counts records without a hash, such as text lines.
+	Unchained int
+	// FirstSeq is where the chain starts in the files read. It is 1 unless
+	// older records were rotated away.
+	FirstSeq          int64
+	LastSeq           int64
+	LastCheckpointSeq int64
+	Signers           []string
+	Problems          []string
+}
+
+func (v LogVerification) OK() bool {
+	return len(v.Problems) == 0
+}
+
+// Uncovered is how many records come after the last checkpoint.
+func (v LogVerification) Uncovered() int64 {
+	return v.LastSeq - v.LastCheckpointSeq
+}
+
+// VerifyLog checks the hash chain and checkpoints of a game log spread
+// over files, oldest first. If trusted is set, every checkpoint must be
+// signed by it.
+func VerifyLog(files []string, trusted ed25519.PublicKey) (LogVerification, error) {
+	v := LogVerification{Signers: []string{}, Problems: []string{}}
+	var prev LogRecord
+	seen := map[int64]bool{}
+	problem := func(file string, format string, args ...any) {
+		v.Problems = append(v.Problems, fmt.Sprintf("%s: %s", file, fmt.Sprintf(format, args...)))
+	}
+
+	for _, file := range files {
+		_, err := ReadLogFile(file, 0, func(r LogRecord) {
+			v.Records++
+			if r.Hash == "" {
+				v.Unchained++
+				if prev.Hash != "" {
+					problem(file, "an unchained record follows entry %d", prev.Seq)
+				}
+				return
+			}
+
+			hash, err := hashRecord(r)
+			if err != nil || hash != r.Hash {
+				problem(file, "entry %d was edited", r.Seq)
+			}
+			switch {
+			case prev.Hash == "":
+				v.FirstSeq = r.Seq
+			case seen[r.Seq]:
+				problem(file, "entry %d appears more than once", r.Seq)
+			case r.Seq < v.LastSeq:
+				problem(file, "entry %d comes after entry %d, out of order", r.Seq, prev.Seq)
+			case r.Seq == prev.Seq+1 && r.PrevHash != prev.Hash:
+				problem(file, "entry %d does not follow entry %d", r.Seq, prev.Seq)
+			}
+			seen[r.Seq] = true
+
+			if r.Event == routing.LogEventCheckpoint {
+				v.Checkpoints++
+				if !checkpointSigned(r, trusted) {
+					problem(file, "checkpoint %d has a bad signature", r.Seq)
+				} else {
+					v.LastCheckpointSeq = max(v.LastCheckpointSeq, r.Seq)
+					if !slices.Contains(v.Signers, r.Signer) {
+						v.Signers = append(v.Signers, r.Signer)
+					}
+				}
+			}
+			prev = r
+			v.LastSeq = max(v.LastSeq, r.Seq)
+		})
+		if err != nil {
+			return v, err
+		}
+	}
+
+	// Entries missing from the middle of the chain were removed
+	for seq := v.FirstSeq; v.FirstSeq > 0 && seq <= v.LastSeq; seq++ {
+		if seen[seq] {
+			continue
+		}
+		end := seq
+		for end+1 <= v.LastSeq && !seen[end+1] {
+			end++
+		}
+		if end == seq {
+			v.Problems = append(v.Problems, fmt.Sprintf("entry %d is missing", seq))
+		} else {
+			v.Problems = append(v.Problems, fmt.Sprintf("entries %d to %d are missing", seq, end))
+		}
+		seq = end
+	}
+	return v, nil
+}
+
+func checkpointSigned(r LogRecord, trusted ed25519.PublicKey) bool {
+	key, err := hex.DecodeString(r.Signer)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	if trusted != nil && !trusted.Equal(ed25519.PublicKey(key)) {
+		return false
+	}
+	sig, err := hex.DecodeString(r.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(key, []byte(r.Hash), sig)
+}
@@ -0,0 +1,113 @@
+package gamelogic
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// testChainedLog writes five logs followed by a checkpoint signed with key
+// and returns the lines of the file.
+func testChainedLog(t *testing.T, key ed25519.PrivateKey) []string {
+	t.Helper()
+	cfg := DefaultLogWriterConfig()
+	cfg.Path = filepath.Join(t.TempDir(), logsFile)
+	cfg.Signer = key
+	w, err := NewLogWriter(cfg)
+	if err != nil {
+		t.Fatalf("NewLogWriter: %v", err)
+	}
+	for i := 1; i <= 5; i++ {
+		w.Write(routing.GameLog{ID: fmt.Sprint(i), GameID: "test", Username: "alice", Message: fmt.Sprintf("log %d", i)}, func(err error) {
+			if err != nil {
+				t.Errorf("writing log %d failed: %v", i, err)
+			}
+		})
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	data, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("the log has %d lines, want 5 logs and a checkpoint", len(lines))
+	}
+	return lines
+}
+
+func TestVerifyLog(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	otherPublic, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	tests := []struct {
+		name    string
+		tamper  func(lines []string) []string
+		trusted ed25519.PublicKey
+		// problem is part of the problem VerifyLog should find, empty for
+		// none
+		problem   string
+		uncovered int64
+	}{
+		{"intact", func(lines []string) []string { return lines }, nil, "", 0},
+		{"edited", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], "log 2", "log 9", 1)
+			return lines
+		}, nil, "entry 2 was edited", 0},
+		{"removed", func(lines []string) []string {
+			return append(lines[:2], lines[3:]...)
+		}, nil, "entry 3 is missing", 0},
+		{"several removed", func(lines []string) []string {
+			return append(lines[:1], lines[4:]...)
+		}, nil, "entries 2 to 4 are missing", 0},
+		{"reordered", func(lines []string) []string {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, nil, "out of order", 0},
+		{"duplicated", func(lines []string) []string {
+			return append(lines[:3], append([]string{lines[2]}, lines[3:]...)...)
+		}, nil, "entry 3 appears more than once", 0},
+		{"line that is not a record", func(lines []string) []string {
+			return append(lines[:3], append([]string{"not a record"}, lines[3:]...)...)
+		}, nil, "", 0},
+		{"untrusted signer", func(lines []string) []string { return lines }, otherPublic, "checkpoint 6 has a bad signature", 6},
+		{"checkpoint cut off", func(lines []string) []string { return lines[:5] }, nil, "", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := tt.tamper(testChainedLog(t, key))
+			path := filepath.Join(t.TempDir(), "tampered.log")
+			err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+			if err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+
+			v, err := VerifyLog([]string{path}, tt.trusted)
+			if err != nil {
+				t.Fatalf("VerifyLog: %v", err)
+			}
+			if tt.problem == "" && !v.OK() {
+				t.Errorf("VerifyLog found %v, want no problems", v.Problems)
+			}
+			if tt.problem != "" && !strings.Contains(strings.Join(v.Problems, "\n"), tt.problem) {
+				t.Errorf("VerifyLog found %v, want %q", v.Problems, tt.problem)
+			}
+			if got := v.Uncovered(); got != tt.uncovered {
+				t.Errorf("%d entries are not covered by a checkpoint, want %d", got, tt.uncovered)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package gamelogic
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat stamps rotated logs. It sorts in time order, so the
+// names of a log's backups sort oldest first.
+const backupTimeFormat = "20060102T150405.000"
+
+// LogFiles returns the files of the log at path, oldest first: its rotated
+// backups, compressed or not, then the live file if it exists.
+func LogFiles(path string) ([]string, error) {
+	files, err := logBackups(path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(path)
+	if err == nil {
+		files = append(files, path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return files, nil
+}
+
+func logBackups(path string) ([]string, error) {
+	base, ext := splitLogPath(path)
+	matches, err := filepath.Glob(base + "-*" + ext + "*")
+	if err != nil {
+		return nil, err
+	}
+	backups := []string{}
+	for _, m := range matches {
+		if strings.HasSuffix(m, ext) || strings.HasSuffix(m, ext+".gz") {
+			backups = append(backups, m)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// splitLogPath splits logs/game.log into logs/game and .log.
+func splitLogPath(path string) (string, string) {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext), ext
+}
+
+func backupName(path string, now time.Time) string {
+	base, ext := splitLogPath(path)
+	name := fmt.Sprintf("%s-%s%s", base, now.Format(backupTimeFormat), ext)
+	for i := 1; fileExists(name) || fileExists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s-%s-%d%s", base, now.Format(backupTimeFormat), i, ext)
+	}
+	return name
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// shouldRotate reports whether the live file is due to be rotated before
+// the next batch is written.
+func (w *LogWriter) shouldRotate(now time.Time) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.cfg.MaxSize > 0 && w.size >= w.cfg.MaxSize {
+		return true
+	}
+	return w.cfg.RotateEvery > 0 && now.Sub(w.openedAt) >= w.cfg.RotateEvery
+}
+
+// rotate moves the live file aside and opens a new one. The old file is
+// compressed and old backups are pruned in the background. An error is only
+// returned with the live file closed if it could not be reopened.
+func (w *LogWriter) rotate(now time.Time) error {
+	err := w.buf.Flush()
+	if err != nil {
+		return fmt.Errorf("could not write to logs file: %v", err)
+	}
+	err = w.file.Close()
+	w.file = nil
+	if err != nil {
+		return fmt.Errorf("could not close logs file: %v", err)
+	}
+	backup := backupName(w.cfg.Path, now)
+	err = os.Rename(w.cfg.Path, backup)
+	if err != nil {
+		// Keep writing to the live file rather than lose logs
+		openErr := w.open()
+		if openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("could not rotate logs file: %v", err)
+	}
+	w.rotatedIDs, w.ids = w.ids, map[string]bool{}
+	err = w.open()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.stats.Rotations++
+	w.mu.Unlock()
+
+	w.background.Add(1)
+	go func() {
+		defer w.background.Done()
+		w.pruneMu.Lock()
+		defer w.pruneMu.Unlock()
+		err := compressLog(backup)
+		if err != nil {
+			log.Printf("Failed to compress %v: %v\n", backup, err)
+		}
+		err = w.prune(time.Now())
+		if err != nil {
+			log.Printf("Failed to prune game logs: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// compressLog gzips path to path.gz and removes path.
+func compressLog(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, path+".gz")
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// prune removes the backups beyond MaxBackups, oldest first, and those last
+// written more than MaxAge ago.
+func (w *LogWriter) prune(now time.Time) error {
+	backups, err := logBackups(w.cfg.Path)
+	if err != nil {
+		return err
+	}
+	for i, backup := range backups {
+		expired := w.cfg.MaxBackups > 0 && i < len(backups)-w.cfg.MaxBackups
+		if !expired && w.cfg.MaxAge > 0 {
+			info, err := os.Stat(backup)
+			if err != nil {
+				return err
+			}
+			expired = now.Sub(info.ModTime()) > w.cfg.MaxAge
+		}
+		if !expired {
+			continue
+		}
+		err = os.Remove(backup)
+		if err != nil {
+			return err
+		}
+		log.Printf("Removed old game log %v.\n", backup)
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package gamelogic
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -13,6 +16,84 @@ const logsFile = "game.log"
 
 const writeToDiskSleep = 1 * time.Second
 
+type LogFormat string
+
+const (
+	// LogFormatJSON writes one LogRecord per line.
+	LogFormatJSON LogFormat = "json"
+	// LogFormatText writes the original `time user: message` lines.
+	LogFormatText LogFormat = "text"
+)
+
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch f := LogFormat(s); f {
+	case LogFormatJSON, LogFormatText:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown log format %q, use %q or %q", s, LogFormatJSON, LogFormatText)
+	}
+}
+
+// LogRecord is a game log as the server writes it: the log itself plus
+// which server instance wrote it and where it is in the hash chain. Time is
+// the publisher's clock and ReceivedAt the server's, which the log is in
+// order of. ReceivedAt is when the server took the log off its queue, not
+// when the broker received it, so a log that waited in the queue is late.
+// ClockSkew is how far ahead of the server the publisher's clock
+// was estimated to be, and Flag says why Time is implausible.
+type LogRecord struct {
+	Seq        int64
+	Time       time.Time
+	ReceivedAt time.Time
+	ClockSkew  time.Duration `json:",omitempty"`
+	Flag       string        `json:",omitempty"`
+	Event      string
+	GameID     string
+	MessageID  string
+	Server     string
+	Username   string
+	Message    string
+	PrevHash   string `json:",omitempty"`
+	Hash       string
+	// Signer and Signature are only set on checkpoints.
+	Signer    string `json:",omitempty"`
+	Signature string `json:",omitempty"`
+	// raw is the line the record was read from, if it was read
+	raw string
+}
+
+// ServerTime is when the server received the log, or the publisher's time
+// for logs written before the server recorded its own.
+func (r LogRecord) ServerTime() time.Time {
+	if r.ReceivedAt.IsZero() {
+		return r.Time
+	}
+	return r.ReceivedAt
+}
+
+func NewLogRecord(gamelog routing.GameLog, server string) LogRecord {
+	event := gamelog.Event
+	if event == "" {
+		event = routing.LogEventMessage
+	}
+	return LogRecord{
+		Time:      gamelog.CurrentTime,
+		Event:     event,
+		GameID:    gamelog.GameID,
+		MessageID: gamelog.ID,
+		Server:    server,
+		Username:  gamelog.Username,
+		Message:   gamelog.Message,
+	}
+}
+
+// NewLogID returns a random ID for a game log.
+func NewLogID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
 func WriteLog(gamelog routing.GameLog) error {
 	log.Printf("received game log...")
 	time.Sleep(writeToDiskSleep)
@@ -23,10 +104,25 @@ func WriteLog(gamelog routing.GameLog) error {
 	}
 	defer f.Close()
 
-	str := fmt.Sprintf("%v %v: %v\n", gamelog.CurrentTime.Format(time.RFC3339), gamelog.Username, gamelog.Message)
-	_, err = f.WriteString(str)
+	line, err := formatLog(LogFormatText, NewLogRecord(gamelog, ""))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
 	if err != nil {
 		return fmt.Errorf("could not write to logs file: %v", err)
 	}
 	return nil
 }
+
+// formatLog is how a game log is written to the logs file.
+func formatLog(format LogFormat, record LogRecord) ([]byte, error) {
+	if format == LogFormatText {
+		return []byte(fmt.Sprintf("%v %v: %v\n", record.Time.Format(time.RFC3339), record.Username, record.Message)), nil
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed JSON marshal game log: %v", err)
+	}
+	return append(line, '\n'), nil
+}
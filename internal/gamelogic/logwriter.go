@@ -0,0 +1,461 @@
+package gamelogic
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type SyncPolicy string
+
+const (
+	// SyncBatch fsyncs after every batch, so an entry is on disk before it
+	// is reported done.
+	SyncBatch SyncPolicy = "batch"
+	// SyncNone leaves flushing to disk to the OS. Entries are reported done
+	// once the OS has them, and may be lost if the machine crashes.
+	SyncNone SyncPolicy = "none"
+)
+
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch p := SyncPolicy(s); p {
+	case SyncBatch, SyncNone:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown sync policy %q, use %q or %q", s, SyncBatch, SyncNone)
+	}
+}
+
+var errLogWriterClosed = errors.New("log writer is closed")
+
+type LogWriterConfig struct {
+	Path   string
+	Format LogFormat
+	// Instance names the server writing the log in every record.
+	Instance string
+	// BatchSize is how many entries are written before a batch is flushed.
+	BatchSize int
+	// FlushInterval is the longest an entry waits for its batch to fill up.
+	FlushInterval time.Duration
+	Sync          SyncPolicy
+	// MaxSize and RotateEvery rotate the file once it holds MaxSize bytes or
+	// has been open for RotateEvery. Either is off when 0.
+	MaxSize     int64
+	RotateEvery time.Duration
+	// MaxBackups and MaxAge limit how many rotated files are kept and for
+	// how long. Either is off when 0.
+	MaxBackups int
+	MaxAge     time.Duration
+	// Signer signs a checkpoint every CheckpointEvery while logs are being
+	// written, and when the writer closes. Checkpoints are off without one,
+	// and JSON records are hash chained either way.
+	Signer          ed25519.PrivateKey
+	CheckpointEvery time.Duration
+	// Clocks corrects and checks the publishers' timestamps, if set.
+	Clocks *ClockSkews
+}
+
+func DefaultLogWriterConfig() LogWriterConfig {
+	return LogWriterConfig{
+		Path:            filepath.Join("logs", logsFile),
+		Format:          LogFormatJSON,
+		BatchSize:       100,
+		FlushInterval:   200 * time.Millisecond,
+		Sync:            SyncBatch,
+		MaxSize:         100 << 20,
+		RotateEvery:     24 * time.Hour,
+		MaxBackups:      30,
+		CheckpointEvery: time.Minute,
+	}
+}
+
+// LogWriterStats reports how the log writer is keeping up. Latency is the
+// time from an entry being handed to the writer to its batch being done.
+type LogWriterStats struct {
+	Entries     int
+	Batches     int
+	Failures    int
+	Rotations   int
+	Duplicates  int
+	LastLatency time.Duration
+	AvgLatency  time.Duration
+	MaxLatency  time.Duration
+}
+
+// LogWriter appends game logs to the log file in batches from a single
+// goroutine, keeping the file open between them. Callers hand it entries
+// with Write and are told when each is durable.
+type LogWriter struct {
+	cfg LogWriterConfig
+	// file, buf, size and openedAt belong to the writing goroutine
+	file     *os.File
+	buf      *bufio.Writer
+	size     int64
+	openedAt time.Time
+	// seq and lastHash are the end of the hash chain
+	seq            int64
+	lastHash       string
+	lastCheckpoint time.Time
+	uncheckpointed int
+	// ids are the message IDs written to the live file, and rotatedIDs
+	// those in the file rotated before it, so a log is only written once
+	ids        map[string]bool
+	rotatedIDs map[string]bool
+
+	entries chan logEntry
+	// flushes asks the writing goroutine to write its batch now
+	flushes chan chan struct{}
+	// sendMu keeps entries in the order they were received
+	sendMu  *sync.Mutex
+	closing chan struct{}
+	stopped chan struct{}
+	stats   LogWriterStats
+	total   time.Duration
+	mu      *sync.Mutex
+	// background tracks the compression and pruning of rotated files
+	background *sync.WaitGroup
+	pruneMu    *sync.Mutex
+}
+
+type logEntry struct {
+	gamelog  routing.GameLog
+	queuedAt time.Time
+	done     func(error)
+}
+
+func NewLogWriter(cfg LogWriterConfig) (*LogWriter, error) {
+	if cfg.BatchSize < 1 {
+		return nil, errors.New("batch size must be at least 1")
+	}
+	if cfg.FlushInterval <= 0 {
+		return nil, errors.New("flush interval must be positive")
+	}
+	if cfg.Format == "" {
+		cfg.Format = LogFormatJSON
+	}
+	err := os.MkdirAll(filepath.Dir(cfg.Path), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("could not create logs directory: %v", err)
+	}
+	w := &LogWriter{
+		cfg:        cfg,
+		entries:    make(chan logEntry, cfg.BatchSize),
+		flushes:    make(chan chan struct{}),
+		sendMu:     &sync.Mutex{},
+		closing:    make(chan struct{}),
+		stopped:    make(chan struct{}),
+		mu:         &sync.Mutex{},
+		background: &sync.WaitGroup{},
+		pruneMu:    &sync.Mutex{},
+	}
+	err = w.resumeChain()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the end of the logs: %v", err)
+	}
+	err = w.loadIDs()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the message IDs in the logs: %v", err)
+	}
+	err = w.open()
+	if err != nil {
+		return nil, err
+	}
+	w.lastCheckpoint = time.Now()
+	go w.run()
+	return w, nil
+}
+
+// open opens the live file, appending to what is already there.
+func (w *LogWriter) open() error {
+	f, err := os.OpenFile(w.cfg.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open logs file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("could not open logs file: %v", err)
+	}
+	w.file = f
+	w.buf = bufio.NewWriter(f)
+	w.size = info.Size()
+	w.openedAt = time.Now()
+	return nil
+}
+
+// Write queues a game log, stamped with the time the server received it.
+// done is called with the result once the batch it is in has been written,
+// and synced if the policy asks for it.
+func (w *LogWriter) Write(gamelog routing.GameLog, done func(error)) {
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+	entry := logEntry{
+		gamelog:  gamelog,
+		queuedAt: time.Now(),
+		done:     done,
+	}
+	select {
+	case w.entries <- entry:
+	case <-w.closing:
+		done(errLogWriterClosed)
+	}
+}
+
+// Flush writes out the entries already queued without waiting for their
+// batch to fill up, and returns once their done funcs have been called.
+func (w *LogWriter) Flush() {
+	flushed := make(chan struct{})
+	select {
+	case w.flushes <- flushed:
+		<-flushed
+	case <-w.stopped:
+	}
+}
+
+// Close writes out the entries already queued and closes the file, once
+// any rotated file is compressed.
+func (w *LogWriter) Close() error {
+	close(w.closing)
+	<-w.stopped
+	w.background.Wait()
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Close()
+}
+
+func (w *LogWriter) Stats() LogWriterStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stats
+}
+
+func (w *LogWriter) run() {
+	defer close(w.stopped)
+	timer := time.NewTimer(w.cfg.FlushInterval)
+	timer.Stop()
+	batch := []logEntry{}
+	for {
+		select {
+		case <-w.closing:
+			for len(w.entries) > 0 {
+				batch = append(batch, <-w.entries)
+			}
+			w.flush(batch)
+			if w.checkpointDue(time.Now(), true) {
+				err := w.writeRecords(nil, time.Now(), true)
+				if err != nil {
+					log.Printf("Failed to write final checkpoint: %v\n", err)
+				}
+			}
+			return
+		case entry := <-w.entries:
+			if len(batch) == 0 {
+				timer.Reset(w.cfg.FlushInterval)
+			}
+			batch = append(batch, entry)
+			if len(batch) >= w.cfg.BatchSize {
+				timer.Stop()
+				w.flush(batch)
+				batch = []logEntry{}
+			}
+		case <-timer.C:
+			w.flush(batch)
+			batch = []logEntry{}
+		case flushed := <-w.flushes:
+			for len(w.entries) > 0 {
+				batch = append(batch, <-w.entries)
+			}
+			timer.Stop()
+			w.flush(batch)
+			batch = []logEntry{}
+			close(flushed)
+		}
+	}
+}
+
+func (w *LogWriter) flush(batch []logEntry) {
+	if len(batch) == 0 {
+		return
+	}
+	err := w.writeBatch(batch)
+	finished := time.Now()
+
+	w.mu.Lock()
+	w.stats.Batches++
+	if err != nil {
+		w.stats.Failures++
+	}
+	for _, entry := range batch {
+		latency := finished.Sub(entry.queuedAt)
+		w.stats.Entries++
+		w.stats.LastLatency = latency
+		w.stats.MaxLatency = max(w.stats.MaxLatency, latency)
+		w.total += latency
+	}
+	w.stats.AvgLatency = w.total / time.Duration(w.stats.Entries)
+	w.mu.Unlock()
+
+	for _, entry := range batch {
+		entry.done(err)
+	}
+}
+
+func (w *LogWriter) writeBatch(batch []logEntry) error {
+	now := time.Now()
+	if w.file == nil {
+		err := w.open()
+		if err != nil {
+			return err
+		}
+	}
+	if w.shouldRotate(now) {
+		err := w.rotate(now)
+		if err != nil && w.file == nil {
+			return err
+		}
+		if err != nil {
+			log.Printf("Failed to rotate game log: %v\n", err)
+		}
+	}
+	records := []LogRecord{}
+	written := map[string]bool{}
+	for _, entry := range batch {
+		id := entry.gamelog.ID
+		if id != "" && (w.ids[id] || w.rotatedIDs[id] || written[id]) {
+			log.Printf("Dropping game log %v from %v, which was already written\n", id, entry.gamelog.Username)
+			w.mu.Lock()
+			w.stats.Duplicates++
+			w.mu.Unlock()
+			continue
+		}
+		if id != "" {
+			written[id] = true
+		}
+		r := NewLogRecord(entry.gamelog, w.cfg.Instance)
+		r.ReceivedAt = entry.queuedAt
+		if w.cfg.Clocks != nil {
+			r.ClockSkew, r.Flag = w.cfg.Clocks.Check(r.Username, r.Time, r.ReceivedAt)
+		}
+		records = append(records, r)
+	}
+	err := w.writeRecords(records, now, false)
+	if err != nil {
+		return err
+	}
+	for id := range written {
+		w.ids[id] = true
+	}
+	return nil
+}
+
+// loadIDs reads the message IDs in the live file and the last rotated one.
+// Older logs are flagged by the clock check if they are sent again.
+func (w *LogWriter) loadIDs() error {
+	w.ids, w.rotatedIDs = map[string]bool{}, map[string]bool{}
+	files, err := LogFiles(w.cfg.Path)
+	if err != nil {
+		return err
+	}
+	for i := max(len(files)-2, 0); i < len(files); i++ {
+		ids := w.rotatedIDs
+		if files[i] == w.cfg.Path {
+			ids = w.ids
+		}
+		_, err := ReadLogFile(files[i], 0, func(r LogRecord) {
+			if r.MessageID != "" {
+				ids[r.MessageID] = true
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRecords chains and writes records, followed by a checkpoint if one
+// is due or final is set. If they can not be written, the chain and the file
+// are rolled back so the records can be written again.
+func (w *LogWriter) writeRecords(records []LogRecord, now time.Time, final bool) (err error) {
+	seq, lastHash := w.seq, w.lastHash
+	lastCheckpoint, uncheckpointed := w.lastCheckpoint, w.uncheckpointed
+	size := w.size
+	defer func() {
+		if err != nil {
+			w.seq, w.lastHash = seq, lastHash
+			w.lastCheckpoint, w.uncheckpointed = lastCheckpoint, uncheckpointed
+			w.discard(size)
+		}
+	}()
+
+	if w.cfg.Format == LogFormatJSON {
+		for i := range records {
+			err = w.chain(&records[i])
+			if err != nil {
+				return err
+			}
+		}
+		w.uncheckpointed += len(records)
+		if w.checkpointDue(now, final) {
+			cp, err := w.checkpoint(now)
+			if err != nil {
+				return err
+			}
+			records = append(records, cp)
+		}
+	}
+	for _, r := range records {
+		line, err := formatLog(w.cfg.Format, r)
+		if err != nil {
+			return err
+		}
+		_, err = w.buf.Write(line)
+		if err != nil {
+			return fmt.Errorf("could not write to logs file: %v", err)
+		}
+		w.size += int64(len(line))
+	}
+	err = w.buf.Flush()
+	if err != nil {
+		return fmt.Errorf("could not write to logs file: %v", err)
+	}
+	if w.cfg.Sync == SyncBatch {
+		err = w.file.Sync()
+		if err != nil {
+			return fmt.Errorf("could not sync logs file: %v", err)
+		}
+	}
+	return nil
+}
+
+// discard drops whatever part of a failed batch is still buffered or has
+// reached the file, so the file ends at size again. A bufio.Writer keeps
+// failing once a write has failed, so it is reset too. If the file can not
+// be cut back, it is closed and opened again for the next batch.
+func (w *LogWriter) discard(size int64) {
+	if w.file == nil {
+		return
+	}
+	w.buf.Reset(w.file)
+	w.size = size
+	err := w.file.Truncate(size)
+	if err != nil {
+		log.Printf("Failed to cut game log back to its last complete batch: %v\n", err)
+		w.file.Close()
+		w.file = nil
+	}
+}
+
+func (s LogWriterStats) String() string {
+	return fmt.Sprintf("%d entries in %d batches, %d failed batches, %d rotations, %d duplicates, latency last %v, avg %v, max %v",
+		s.Entries, s.Batches, s.Failures, s.Rotations, s.Duplicates, s.LastLatency, s.AvgLatency, s.MaxLatency)
+}
@@ -0,0 +1,94 @@
+package gamelogic
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// testLogWriter writes to a temporary directory and only flushes a batch
+// once it is full or an hour has passed.
+func testLogWriter(t *testing.T) (*LogWriter, LogWriterConfig) {
+	t.Helper()
+	cfg := DefaultLogWriterConfig()
+	cfg.Path = filepath.Join(t.TempDir(), logsFile)
+	cfg.FlushInterval = time.Hour
+	w, err := NewLogWriter(cfg)
+	if err != nil {
+		t.Fatalf("NewLogWriter: %v", err)
+	}
+	return w, cfg
+}
+
+func TestLogWriterFlush(t *testing.T) {
+	w, _ := testLogWriter(t)
+	defer w.Close()
+
+	written := make(chan error, 1)
+	w.Write(routing.GameLog{ID: "1", GameID: "test", Username: "alice", Message: "hello"}, func(err error) {
+		written <- err
+	})
+	w.Flush()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Errorf("writing the log failed: %v", err)
+		}
+	default:
+		t.Error("Flush returned before the queued log was written")
+	}
+}
+
+func TestLogWriterDropsDuplicates(t *testing.T) {
+	w, cfg := testLogWriter(t)
+	write := func(w *LogWriter, id string) {
+		t.Helper()
+		written := make(chan error, 1)
+		w.Write(routing.GameLog{ID: id, GameID: "test", Username: "alice", Message: "hello " + id}, func(err error) {
+			written <- err
+		})
+		w.Flush()
+		err := <-written
+		if err != nil {
+			t.Fatalf("writing log %v failed: %v", id, err)
+		}
+	}
+	write(w, "1")
+	write(w, "1")
+	write(w, "2")
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// A restarted writer still knows what it wrote
+	w, err = NewLogWriter(cfg)
+	if err != nil {
+		t.Fatalf("NewLogWriter: %v", err)
+	}
+	write(w, "2")
+	write(w, "3")
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	ids := []string{}
+	_, err = ReadLogFile(cfg.Path, 0, func(r LogRecord) {
+		if r.MessageID != "" {
+			ids = append(ids, r.MessageID)
+		}
+	})
+	if err != nil {
+		t.Fatalf("ReadLogFile: %v", err)
+	}
+	if strings.Join(ids, ",") != "1,2,3" {
+		t.Errorf("the log holds messages %v, want 1, 2 and 3 once each", ids)
+	}
+	if got := w.Stats().Duplicates; got != 1 {
+		t.Errorf("the restarted writer dropped %d duplicates, want 1", got)
+	}
+}
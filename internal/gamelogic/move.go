@@ -1,6 +1,8 @@
 package gamelogic
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strconv"
@@ -14,6 +16,8 @@ const (
 	MoveOutcomeMakeWar
 )
 
+// HandleMove reacts to another player's move. The server declares the
+// battle if the move starts one.
 func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 	defer fmt.Println("------------------------")
 	player := gs.GetPlayerSnap()
@@ -29,27 +33,27 @@ func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 		return MoveOutcomeSamePlayer
 	}
 
-	overlappingLocation := getOverlappingLocation(player, move.Player)
-	if overlappingLocation != "" {
-		fmt.Printf("You have units in %s! You are at war with %s!\n", overlappingLocation, move.Player.Username)
+	gs.rememberPlayer(move.Player)
+	if gs.atWar(move.Player, move.ToLocation) {
+		fmt.Printf("You have units in %s! You are at war with %s!\n", move.ToLocation, move.Player.Username)
 		return MoveOutcomeMakeWar
 	}
 	fmt.Printf("You are safe from %s's units.\n", move.Player.Username)
 	return MoveOutComeSafe
 }
 
-func getOverlappingLocation(p1 Player, p2 Player) Location {
-	for _, u1 := range p1.Units {
-		for _, u2 := range p2.Units {
-			if u1.Location == u2.Location {
-				return u1.Location
-			}
-		}
-	}
-	return ""
+// NewMoveID returns a random ID for a move, which the battle it starts is
+// named after.
+func NewMoveID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
 }
 
 func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
+	if gs.isOver() {
+		return ArmyMove{}, errors.New("the game is over, you can not move units")
+	}
 	if gs.isPaused() {
 		return ArmyMove{}, errors.New("the game is paused, you can not move units")
 	}
@@ -57,8 +61,7 @@ func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
 		return ArmyMove{}, errors.New("usage: move <location> <unitID> <unitID> <unitID> etc")
 	}
 	newLocation := Location(words[1])
-	locations := getAllLocations()
-	if _, ok := locations[newLocation]; !ok {
+	if !gs.Rules.hasLocation(newLocation) {
 		return ArmyMove{}, fmt.Errorf("error: %s is not a valid location", newLocation)
 	}
 	unitIDs := []int{}
@@ -71,22 +74,87 @@ func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
 		unitIDs = append(unitIDs, unitID)
 	}
 
-	newUnits := []Unit{}
 	for _, unitID := range unitIDs {
 		unit, ok := gs.GetUnit(unitID)
 		if !ok {
 			return ArmyMove{}, fmt.Errorf("error: unit with ID %v not found", unitID)
 		}
+		if !gs.Rules.isAdjacent(unit.Location, newLocation) {
+			return ArmyMove{}, fmt.Errorf("error: unit %v can not reach %s from %s", unitID, newLocation, unit.Location)
+		}
+	}
+	if max := gs.Rules.Limits.MaxUnitsPerLocation; max > 0 {
+		moving := map[int]struct{}{}
+		for _, unitID := range unitIDs {
+			moving[unitID] = struct{}{}
+		}
+		count := len(moving)
+		for _, unit := range gs.getUnitsSnap() {
+			if _, ok := moving[unit.ID]; !ok && unit.Location == newLocation {
+				count++
+			}
+		}
+		if count > max {
+			return ArmyMove{}, fmt.Errorf("error: %s can hold at most %d of your units", newLocation, max)
+		}
+	}
+
+	cost := 0
+	for _, unitID := range unitIDs {
+		unit, _ := gs.GetUnit(unitID)
+		rr, _ := gs.Rules.rank(unit.Rank)
+		cost += rr.MoveCost
+	}
+	err := gs.spend(cost, fmt.Sprintf("moving %d unit(s)", len(unitIDs)))
+	if err != nil {
+		return ArmyMove{}, err
+	}
+
+	from := []Unit{}
+	newUnits := []Unit{}
+	for _, unitID := range unitIDs {
+		unit, _ := gs.GetUnit(unitID)
+		from = append(from, unit)
 		unit.Location = newLocation
 		gs.UpdateUnit(unit)
 		newUnits = append(newUnits, unit)
 	}
 
 	mv := ArmyMove{
+		ID:         NewMoveID(),
 		ToLocation: newLocation,
 		Units:      newUnits,
 		Player:     gs.GetPlayerSnap(),
 	}
+	gs.mu.Lock()
+	gs.lastMove = pendingMove{id: mv.ID, cost: cost, from: from}
+	gs.mu.Unlock()
 	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
 	return mv, nil
 }
+
+type pendingMove struct {
+	id   string
+	cost int
+	from []Unit
+}
+
+// CancelMove takes back a move that could not be published: its units go
+// back where they were and its cost is refunded. Only the last move made can
+// be taken back.
+func (gs *GameState) CancelMove(move ArmyMove) {
+	defer gs.changed()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	last := gs.lastMove
+	if last.id == "" || last.id != move.ID {
+		return
+	}
+	gs.lastMove = pendingMove{}
+	gs.Treasury.Gold += last.cost
+	for _, u := range last.from {
+		if current, ok := gs.Player.Units[u.ID]; ok && current.Location == move.ToLocation {
+			gs.Player.Units[u.ID] = u
+		}
+	}
+}
@@ -2,18 +2,67 @@ package gamelogic
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
-func (gs *GameState) HandlePause(ps routing.PlayingState) {
+// HandlePause applies a pause, resume or pause warning and reports whether
+// it did. States older than the last one applied are ignored, so a late
+// delivery can not undo a newer one.
+func (gs *GameState) HandlePause(ps routing.PlayingState) bool {
+	if !gs.newerPause(ps) {
+		return false
+	}
+	if !ps.PausingAt.IsZero() && !time.Now().Before(ps.PausingAt) {
+		// A warning for a pause that never happened, such as one read by a
+		// late joiner after the pause was cancelled
+		gs.setPaused(ps.IsPaused)
+		return false
+	}
 	defer fmt.Println("------------------------")
 	fmt.Println()
-	if ps.IsPaused {
+	switch {
+	case !ps.PausingAt.IsZero():
+		fmt.Println("==== Pause Warning ====")
+		fmt.Printf("The game pauses in %v, at %s: %s.\n",
+			time.Until(ps.PausingAt).Round(time.Second), ps.PausingAt.Format(time.TimeOnly), ps.Reason)
+	case ps.IsPaused:
 		fmt.Println("==== Pause Detected ====")
+		if ps.Reason != "" {
+			fmt.Printf("Reason: %s\n", ps.Reason)
+		}
+		if !ps.ResumeAt.IsZero() {
+			fmt.Printf("The game resumes at %s.\n", ps.ResumeAt.Format(time.TimeOnly))
+		}
+	default:
+		fmt.Println("==== Resume Detected ====")
+	}
+
+	gs.setPaused(ps.IsPaused)
+	return true
+}
+
+func (gs *GameState) setPaused(paused bool) {
+	if paused {
 		gs.pauseGame()
 	} else {
-		fmt.Println("==== Resume Detected ====")
 		gs.resumeGame()
 	}
 }
+
+func (gs *GameState) newerPause(ps routing.PlayingState) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if ps.Version <= gs.pauseState.Version {
+		return false
+	}
+	gs.pauseState = ps
+	return true
+}
+
+func (gs *GameState) getPauseState() routing.PlayingState {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.pauseState
+}
@@ -0,0 +1,201 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type PresenceKind string
+
+const (
+	PresenceJoin      PresenceKind = "join"
+	PresenceLeave     PresenceKind = "leave"
+	PresenceHeartbeat PresenceKind = "heartbeat"
+)
+
+const (
+	HeartbeatInterval = 5 * time.Second
+	// PresenceTimeout is how long the server waits for a heartbeat before it
+	// considers a client dead, three missed heartbeats.
+	PresenceTimeout = 3 * HeartbeatInterval
+)
+
+// PresenceEvent is published by clients when they join, leave and on every
+// heartbeat in between. PublicKey is the key the client claimed its
+// username with.
+type PresenceEvent struct {
+	Username  string
+	Kind      PresenceKind
+	SentAt    time.Time
+	PublicKey []byte
+}
+
+type PlayerPresence struct {
+	Username string
+	Online   bool
+	JoinedAt time.Time
+	LastSeen time.Time
+	// lastSent is the SentAt of the player's latest presence event
+	lastSent time.Time
+}
+
+// PresenceChange is broadcast by the server whenever a player comes online
+// or goes offline. Players is the full roster after the change, so a client
+// that just joined learns who else is playing.
+type PresenceChange struct {
+	Username string
+	Online   bool
+	Reason   string
+	Players  []PlayerPresence
+}
+
+// Roster is the server's record of every player that has been seen in a
+// game and when they were last heard from.
+type Roster struct {
+	players map[string]*PlayerPresence
+	mu      *sync.Mutex
+}
+
+func NewRoster() *Roster {
+	return &Roster{
+		players: map[string]*PlayerPresence{},
+		mu:      &sync.Mutex{},
+	}
+}
+
+// Apply records a presence event received at now. It reports a change if
+// the player came online or went offline. Events are not checked against
+// the server's clock, since a client's may be off, so one sent no later than
+// the player's last event is taken as a replay and ignored.
+func (r *Roster) Apply(ev PresenceEvent, now time.Time) (PresenceChange, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.players[ev.Username]
+	if !ok {
+		p = &PlayerPresence{Username: ev.Username}
+		r.players[ev.Username] = p
+	}
+	if !ev.SentAt.After(p.lastSent) {
+		return PresenceChange{}, false
+	}
+	p.lastSent = ev.SentAt
+	wasOnline := p.Online
+	p.LastSeen = now
+
+	switch ev.Kind {
+	case PresenceJoin:
+		p.Online = true
+		p.JoinedAt = now
+		return r.change(p, "joined"), true
+	case PresenceLeave:
+		p.Online = false
+		if !wasOnline {
+			return PresenceChange{}, false
+		}
+		return r.change(p, "left"), true
+	case PresenceHeartbeat:
+		if wasOnline {
+			return PresenceChange{}, false
+		}
+		// A heartbeat from a player we timed out, or that was playing before
+		// this server started
+		p.Online = true
+		if p.JoinedAt.IsZero() {
+			p.JoinedAt = now
+		}
+		return r.change(p, "is back"), true
+	default:
+		return PresenceChange{}, false
+	}
+}
+
+// Expire marks every online player that has not been heard from within
+// PresenceTimeout as offline.
+func (r *Roster) Expire(now time.Time) []PresenceChange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changes := []PresenceChange{}
+	for _, username := range r.usernames() {
+		p := r.players[username]
+		if p.Online && now.Sub(p.LastSeen) > PresenceTimeout {
+			p.Online = false
+			changes = append(changes, r.change(p, "timed out"))
+		}
+	}
+	return changes
+}
+
+func (r *Roster) Players() []PlayerPresence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshot()
+}
+
+func (r *Roster) change(p *PlayerPresence, reason string) PresenceChange {
+	return PresenceChange{
+		Username: p.Username,
+		Online:   p.Online,
+		Reason:   reason,
+		Players:  r.snapshot(),
+	}
+}
+
+func (r *Roster) snapshot() []PlayerPresence {
+	players := []PlayerPresence{}
+	for _, username := range r.usernames() {
+		players = append(players, *r.players[username])
+	}
+	return players
+}
+
+func (r *Roster) usernames() []string {
+	usernames := []string{}
+	for username := range r.players {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+func (c PresenceChange) String() string {
+	return fmt.Sprintf("%s %s", c.Username, c.Reason)
+}
+
+func PrintPlayers(players []PlayerPresence) {
+	fmt.Println("Players:")
+	if len(players) == 0 {
+		fmt.Println("* nobody yet")
+	}
+	for _, p := range players {
+		if p.Online {
+			fmt.Printf("* %s: online since %s, last seen %s\n",
+				p.Username, p.JoinedAt.Format(time.TimeOnly), p.LastSeen.Format(time.TimeOnly))
+		} else {
+			fmt.Printf("* %s: offline, last seen %s\n", p.Username, p.LastSeen.Format(time.TimeOnly))
+		}
+	}
+}
+
+// HandlePresence updates the roster and reports whether the change was
+// printed. Our own presence changes are not.
+func (gs *GameState) HandlePresence(change PresenceChange) bool {
+	gs.mu.Lock()
+	gs.roster = change.Players
+	gs.mu.Unlock()
+
+	if change.Username == gs.GetUsername() {
+		return false
+	}
+	fmt.Println()
+	fmt.Printf("%v.\n", change)
+	return true
+}
+
+// CommandPlayers prints the roster from the server's last presence change.
+func (gs *GameState) CommandPlayers() {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	PrintPlayers(gs.roster)
+}
@@ -0,0 +1,252 @@
+package gamelogic
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ServerName is the name the server signs its messages and writes the game
+// log under. No player can claim it.
+const ServerName = "server"
+
+type RegistryAction string
+
+const (
+	RegistryActionClaim   RegistryAction = "claim"
+	RegistryActionRelease RegistryAction = "release"
+	RegistryActionKey     RegistryAction = "key"
+)
+
+// RegistryRequest is sent by clients to claim a username before they play,
+// to release it when they stop and to look up another player's public key.
+// Players make their own key: a claim carries its public key, and both
+// claims and releases carry Proof, the player's signature over ClaimMessage
+// or ReleaseMessage.
+type RegistryRequest struct {
+	Action    RegistryAction
+	Username  string
+	PublicKey []byte
+	Proof     []byte
+}
+
+// RegistryResponse is the server's reply. Error is empty on success. A key
+// lookup is answered with the player's public key, or the server's for
+// ServerName.
+type RegistryResponse struct {
+	Error     string
+	PublicKey []byte
+}
+
+// Registry is the server's list of usernames in use. Queue names are derived
+// from usernames, so two clients can not share one. Each name is bound to
+// the public key its player signs their messages with. Every change is
+// written to its file, so players are still known after a restart.
+type Registry struct {
+	path      string
+	names     map[string]*claim
+	serverKey ed25519.PublicKey
+	mu        *sync.Mutex
+}
+
+// ClaimTimeout is how long a claimed username is kept for a player that is
+// never seen playing, such as a client that crashed in the lobby.
+const ClaimTimeout = 30 * time.Minute
+
+// claim is kept after its name is released, so messages the player sent
+// before that can still be verified. Claiming the name again replaces it.
+type claim struct {
+	Username  string
+	PublicKey ed25519.PublicKey
+	ClaimedAt time.Time
+	// SeenAt is when the player was last seen coming online, zero if they
+	// have not been since they claimed the name
+	SeenAt time.Time
+	Active bool
+}
+
+// LoadRegistry reads the registry at path. A missing file is an empty
+// registry.
+func LoadRegistry(path string, serverKey ed25519.PublicKey) (*Registry, error) {
+	r := &Registry{
+		path:      path,
+		names:     map[string]*claim{},
+		serverKey: serverKey,
+		mu:        &sync.Mutex{},
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	claims := []*claim{}
+	err = json.Unmarshal(data, &claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed JSON unmarshal registry: %v", err)
+	}
+	for _, c := range claims {
+		r.names[c.Username] = c
+	}
+	return r, nil
+}
+
+// Claim reserves a username for the holder of key. A player may claim their
+// name again with the same key, such as after their client crashed.
+func (r *Registry) Claim(username string, key ed25519.PublicKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := ValidateUsername(username)
+	if err != nil {
+		return err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return errors.New("a claim needs an Ed25519 public key")
+	}
+	c, ok := r.names[username]
+	if username == ServerName || ok && c.Active && !c.PublicKey.Equal(key) {
+		return fmt.Errorf("username %s is already taken", username)
+	}
+	r.names[username] = &claim{
+		Username:  username,
+		PublicKey: key,
+		ClaimedAt: time.Now(),
+		Active:    true,
+	}
+	return r.save()
+}
+
+// Hold marks a username as in use by the holder of key. It is used for
+// players that are seen playing, such as a client coming back after its
+// presence expired. A name is only held for the key it was claimed with, so
+// a client can not take back a name someone else claimed in the meantime.
+// Names that were never claimed are left alone, since there is no key to
+// check their messages with.
+func (r *Registry) Hold(username string, key ed25519.PublicKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.names[username]
+	if !ok {
+		return nil
+	}
+	if !c.PublicKey.Equal(key) {
+		return fmt.Errorf("username %s is claimed with another key", username)
+	}
+	c.Active = true
+	c.SeenAt = time.Now()
+	return r.save()
+}
+
+// Expire releases every username claimed more than ClaimTimeout before now
+// by a player that has not been seen playing since, and returns them.
+func (r *Registry) Expire(now time.Time) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	expired := []string{}
+	for name, c := range r.names {
+		if c.Active && c.SeenAt.IsZero() && now.Sub(c.ClaimedAt) > ClaimTimeout {
+			c.Active = false
+			expired = append(expired, name)
+		}
+	}
+	if len(expired) == 0 {
+		return expired, nil
+	}
+	sort.Strings(expired)
+	return expired, r.save()
+}
+
+// Release frees a username. Releasing a name nobody holds does nothing.
+func (r *Registry) Release(username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.names[username]
+	if !ok || !c.Active {
+		return nil
+	}
+	c.Active = false
+	return r.save()
+}
+
+// Revoke frees a username and forgets its key, so messages signed with it
+// no longer verify. The player has to claim their name again to play.
+func (r *Registry) Revoke(username string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.names[username]; !ok {
+		return nil
+	}
+	delete(r.names, username)
+	return r.save()
+}
+
+// PublicKey returns the key a player's messages are checked against. The
+// server's own key is returned for ServerName.
+func (r *Registry) PublicKey(username string) (ed25519.PublicKey, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if username == ServerName {
+		return r.serverKey, true
+	}
+	c, ok := r.names[username]
+	if !ok {
+		return nil, false
+	}
+	return c.PublicKey, true
+}
+
+func (r *Registry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := []string{}
+	for name, c := range r.names {
+		if c.Active {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Registry) save() error {
+	claims := []*claim{}
+	for _, c := range r.names {
+		claims = append(claims, c)
+	}
+	sort.Slice(claims, func(i, j int) bool {
+		return claims[i].Username < claims[j].Username
+	})
+	data, err := json.MarshalIndent(claims, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed JSON marshal registry: %v", err)
+	}
+	tmp := r.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// ClaimMessage is what a player signs to prove they hold the key they claim
+// a username with.
+func ClaimMessage(username string, key ed25519.PublicKey) []byte {
+	return []byte("claim " + username + " " + hex.EncodeToString(key))
+}
+
+// ReleaseMessage is what a player signs to prove a release request is theirs.
+func ReleaseMessage(username string) []byte {
+	return []byte("release " + username)
+}
+
+// UsernameRegistry is how ClientWelcome claims a username from the server.
+type UsernameRegistry interface {
+	Claim(username string) error
+}
@@ -0,0 +1,134 @@
+package gamelogic
+
+import (
+	"crypto/ed25519"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T) ed25519.PublicKey {
+	t.Helper()
+	key, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+func testRegistry(t *testing.T) (*Registry, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "usernames.json")
+	r, err := LoadRegistry(path, testKey(t))
+	if err != nil {
+		t.Fatalf("LoadRegistry: %v", err)
+	}
+	return r, path
+}
+
+func TestRegistryClaim(t *testing.T) {
+	alice, bob := testKey(t), testKey(t)
+	tests := []struct {
+		name     string
+		before   func(r *Registry)
+		username string
+		key      ed25519.PublicKey
+		ok       bool
+	}{
+		{"free name", func(r *Registry) {}, "alice", alice, true},
+		{"own name again", func(r *Registry) { r.Claim("alice", alice) }, "alice", alice, true},
+		{"taken name", func(r *Registry) { r.Claim("alice", alice) }, "alice", bob, false},
+		{"released name", func(r *Registry) {
+			r.Claim("alice", alice)
+			r.Release("alice")
+		}, "alice", bob, true},
+		{"revoked name", func(r *Registry) {
+			r.Claim("alice", alice)
+			r.Revoke("alice")
+		}, "alice", bob, true},
+		{"server name", func(r *Registry) {}, ServerName, alice, false},
+		{"invalid name", func(r *Registry) {}, "a.b", alice, false},
+		{"no key", func(r *Registry) {}, "alice", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := testRegistry(t)
+			tt.before(r)
+			err := r.Claim(tt.username, tt.key)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Claim returned %v, want ok %v", err, tt.ok)
+			}
+			key, _ := r.PublicKey(tt.username)
+			if tt.ok && !key.Equal(tt.key) {
+				t.Errorf("the name is bound to %x, want %x", key, tt.key)
+			}
+		})
+	}
+}
+
+func TestRegistryHold(t *testing.T) {
+	alice, bob := testKey(t), testKey(t)
+	r, _ := testRegistry(t)
+	err := r.Claim("alice", alice)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	r.Release("alice")
+	if r.Hold("alice", bob) == nil {
+		t.Error("a name was held for a key it was not claimed with")
+	}
+	err = r.Hold("alice", alice)
+	if err != nil {
+		t.Errorf("Hold with the claimed key: %v", err)
+	}
+	if names := r.Names(); len(names) != 1 || names[0] != "alice" {
+		t.Errorf("the names in use are %v, want alice", names)
+	}
+	if r.Hold("bob", bob) != nil || len(r.Names()) != 1 {
+		t.Error("a name that was never claimed was held")
+	}
+}
+
+func TestRegistryExpire(t *testing.T) {
+	r, _ := testRegistry(t)
+	r.Claim("alice", testKey(t))
+	bob := testKey(t)
+	r.Claim("bob", bob)
+	r.Hold("bob", bob)
+
+	expired, err := r.Expire(time.Now())
+	if err != nil || len(expired) != 0 {
+		t.Fatalf("Expire right after claiming returned %v, %v", expired, err)
+	}
+	expired, err = r.Expire(time.Now().Add(ClaimTimeout + time.Minute))
+	if err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+	if len(expired) != 1 || expired[0] != "alice" {
+		t.Errorf("expired %v, want only alice, who was never seen playing", expired)
+	}
+	if _, ok := r.PublicKey("alice"); !ok {
+		t.Error("an expired claim's key was forgotten, so its old messages no longer verify")
+	}
+}
+
+func TestRegistryPersists(t *testing.T) {
+	r, path := testRegistry(t)
+	alice := testKey(t)
+	err := r.Claim("alice", alice)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+
+	loaded, err := LoadRegistry(path, testKey(t))
+	if err != nil {
+		t.Fatalf("LoadRegistry: %v", err)
+	}
+	key, ok := loaded.PublicKey("alice")
+	if !ok || !key.Equal(alice) {
+		t.Errorf("alice's key after a restart is %x, want %x", key, alice)
+	}
+	if loaded.Claim("alice", testKey(t)) == nil {
+		t.Error("a name claimed before a restart was taken with another key")
+	}
+}
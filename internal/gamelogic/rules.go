@@ -0,0 +1,391 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// RulesVersion is the rules file format understood by this build.
+const RulesVersion = 1
+
+type Rules struct {
+	Version   int            `json:"version"`
+	Name      string         `json:"name"`
+	Locations []LocationRule `json:"locations"`
+	Ranks     []RankRule     `json:"ranks"`
+	Limits    Limits         `json:"limits"`
+	Combat    Combat         `json:"combat"`
+	Economy   Economy        `json:"economy"`
+	Victory   Victory        `json:"victory"`
+	Lobby     LobbyRules     `json:"lobby"`
+}
+
+type LocationRule struct {
+	Name     Location   `json:"name"`
+	Adjacent []Location `json:"adjacent"`
+	// DefenseBonus multiplies the damage dealt by units defending this
+	// location. Zero means no terrain bonus.
+	DefenseBonus float64 `json:"defense_bonus"`
+}
+
+type RankRule struct {
+	Name  UnitRank `json:"name"`
+	Power int      `json:"power"`
+	// Cost is paid once to spawn the unit, Upkeep on every economy tick and
+	// MoveCost every time the unit moves.
+	Cost     int `json:"cost"`
+	Upkeep   int `json:"upkeep"`
+	MoveCost int `json:"move_cost"`
+	// Effectiveness multiplies the damage this rank deals to the given rank.
+	// Ranks that are not listed take normal damage.
+	Effectiveness map[UnitRank]float64 `json:"effectiveness"`
+}
+
+// Limits caps what a single player may field. Zero means unlimited.
+type Limits struct {
+	MaxUnits            int `json:"max_units"`
+	MaxUnitsPerLocation int `json:"max_units_per_location"`
+	// SpawnOnlyInOwned restricts spawning to owned locations. A player that
+	// owns nothing may spawn in any unowned location.
+	SpawnOnlyInOwned bool `json:"spawn_only_in_owned"`
+}
+
+// Combat tunes battle resolution. Zero values fall back to the defaults.
+type Combat struct {
+	MaxRounds int `json:"max_rounds"`
+	// CombinedArmsBonus is added to the damage multiplier of a stack for
+	// every distinct rank in it beyond the first.
+	CombinedArmsBonus float64 `json:"combined_arms_bonus"`
+	// DefenderBonus is added to the damage multiplier of the defending side.
+	DefenderBonus float64 `json:"defender_bonus"`
+}
+
+const defaultMaxRounds = 3
+
+// Economy controls the gold players earn and spend. The server sends an
+// economy tick every TickSeconds; on each tick every player earns
+// IncomePerLocation for each location they control and pays upkeep.
+type Economy struct {
+	StartingGold      int `json:"starting_gold"`
+	IncomePerLocation int `json:"income_per_location"`
+	TickSeconds       int `json:"tick_seconds"`
+}
+
+const defaultTickSeconds = 30
+
+// LobbyRules controls when a match starts. MaxPlayers of zero is unlimited,
+// up to one player per location.
+type LobbyRules struct {
+	MinPlayers int `json:"min_players"`
+	MaxPlayers int `json:"max_players"`
+}
+
+const defaultMinPlayers = 2
+
+// Victory lists the ways a game can end. Conditions left at their zero value
+// are disabled. A game with a time limit ends with the highest score winning.
+type Victory struct {
+	ControlLocations int  `json:"control_locations"`
+	EliminateAll     bool `json:"eliminate_all"`
+	TimeLimitSeconds int  `json:"time_limit_seconds"`
+}
+
+// DefaultRules is the built-in ruleset: six fully connected continents and
+// the classic infantry/cavalry/artillery ranks.
+func DefaultRules() Rules {
+	names := []Location{
+		"americas",
+		"europe",
+		"africa",
+		"asia",
+		"australia",
+		"antarctica",
+	}
+	terrain := map[Location]float64{
+		"europe":     0.1,
+		"asia":       0.1,
+		"antarctica": 0.25,
+	}
+	locations := []LocationRule{}
+	for _, name := range names {
+		adjacent := []Location{}
+		for _, other := range names {
+			if other != name {
+				adjacent = append(adjacent, other)
+			}
+		}
+		locations = append(locations, LocationRule{
+			Name:         name,
+			Adjacent:     adjacent,
+			DefenseBonus: terrain[name],
+		})
+	}
+
+	return Rules{
+		Version:   RulesVersion,
+		Name:      "classic",
+		Locations: locations,
+		Ranks: []RankRule{
+			{
+				Name:          RankInfantry,
+				Power:         1,
+				Cost:          1,
+				Upkeep:        0,
+				MoveCost:      0,
+				Effectiveness: map[UnitRank]float64{RankCavalry: 1.5},
+			},
+			{
+				Name:          RankCavalry,
+				Power:         5,
+				Cost:          5,
+				Upkeep:        1,
+				MoveCost:      0,
+				Effectiveness: map[UnitRank]float64{RankArtillery: 2, RankInfantry: 0.75},
+			},
+			{
+				Name:          RankArtillery,
+				Power:         10,
+				Cost:          10,
+				Upkeep:        2,
+				MoveCost:      1,
+				Effectiveness: map[UnitRank]float64{RankCavalry: 0.5},
+			},
+		},
+		Combat: Combat{
+			MaxRounds:         defaultMaxRounds,
+			CombinedArmsBonus: 0.1,
+			DefenderBonus:     0.1,
+		},
+		Economy: Economy{
+			StartingGold:      20,
+			IncomePerLocation: 3,
+			TickSeconds:       defaultTickSeconds,
+		},
+		// Spawning is cheap and allowed anywhere, so controlling locations
+		// would be won by whoever spawns fastest. Rules files can opt in.
+		Victory: Victory{
+			EliminateAll: true,
+		},
+		Lobby: LobbyRules{
+			MinPlayers: defaultMinPlayers,
+		},
+	}
+}
+
+// LoadRules reads and validates a JSON rules file. An empty path returns the
+// built-in default ruleset.
+func LoadRules(path string) (Rules, error) {
+	if path == "" {
+		return DefaultRules(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Rules{}, fmt.Errorf("could not read rules file: %v", err)
+	}
+
+	var rules Rules
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return Rules{}, fmt.Errorf("could not parse rules file: %v", err)
+	}
+
+	err = rules.Validate()
+	if err != nil {
+		return Rules{}, fmt.Errorf("invalid rules file %s: %v", path, err)
+	}
+	return rules, nil
+}
+
+func (r Rules) Validate() error {
+	if r.Version != RulesVersion {
+		return fmt.Errorf("unsupported rules version %d, expected %d", r.Version, RulesVersion)
+	}
+	if len(r.Locations) == 0 {
+		return errors.New("at least one location is required")
+	}
+	if len(r.Ranks) == 0 {
+		return errors.New("at least one rank is required")
+	}
+
+	locations := map[Location]LocationRule{}
+	for _, loc := range r.Locations {
+		if loc.Name == "" {
+			return errors.New("location with empty name")
+		}
+		if _, ok := locations[loc.Name]; ok {
+			return fmt.Errorf("duplicate location %s", loc.Name)
+		}
+		locations[loc.Name] = loc
+	}
+	for _, loc := range r.Locations {
+		for _, adj := range loc.Adjacent {
+			other, ok := locations[adj]
+			if !ok {
+				return fmt.Errorf("location %s is adjacent to unknown location %s", loc.Name, adj)
+			}
+			if adj == loc.Name {
+				return fmt.Errorf("location %s is adjacent to itself", loc.Name)
+			}
+			if !containsLocation(other.Adjacent, loc.Name) {
+				return fmt.Errorf("adjacency between %s and %s is not symmetric", loc.Name, adj)
+			}
+		}
+	}
+
+	ranks := map[UnitRank]struct{}{}
+	for _, rank := range r.Ranks {
+		if rank.Name == "" {
+			return errors.New("rank with empty name")
+		}
+		if _, ok := ranks[rank.Name]; ok {
+			return fmt.Errorf("duplicate rank %s", rank.Name)
+		}
+		if rank.Power <= 0 {
+			return fmt.Errorf("rank %s must have a positive power", rank.Name)
+		}
+		if rank.Cost < 0 || rank.Upkeep < 0 || rank.MoveCost < 0 {
+			return fmt.Errorf("rank %s has a negative cost", rank.Name)
+		}
+		ranks[rank.Name] = struct{}{}
+	}
+	for _, rank := range r.Ranks {
+		for target, mult := range rank.Effectiveness {
+			if _, ok := ranks[target]; !ok {
+				return fmt.Errorf("rank %s has an effectiveness against unknown rank %s", rank.Name, target)
+			}
+			if mult < 0 {
+				return fmt.Errorf("rank %s has a negative effectiveness against %s", rank.Name, target)
+			}
+		}
+	}
+	for _, loc := range r.Locations {
+		if loc.DefenseBonus < 0 {
+			return fmt.Errorf("location %s has a negative defense bonus", loc.Name)
+		}
+	}
+
+	if r.Limits.MaxUnits < 0 || r.Limits.MaxUnitsPerLocation < 0 {
+		return errors.New("limits must not be negative")
+	}
+	if r.Combat.MaxRounds < 0 {
+		return errors.New("combat max_rounds must not be negative")
+	}
+	if r.Combat.CombinedArmsBonus < 0 || r.Combat.DefenderBonus < 0 {
+		return errors.New("combat bonuses must not be negative")
+	}
+	if r.Economy.StartingGold < 0 || r.Economy.IncomePerLocation < 0 || r.Economy.TickSeconds < 0 {
+		return errors.New("economy values must not be negative")
+	}
+	if r.Victory.ControlLocations < 0 || r.Victory.ControlLocations > len(r.Locations) {
+		return fmt.Errorf("victory control_locations must be between 0 and %d", len(r.Locations))
+	}
+	if r.Victory.TimeLimitSeconds < 0 {
+		return errors.New("victory time_limit_seconds must not be negative")
+	}
+	if r.Lobby.MinPlayers < 0 || r.Lobby.MaxPlayers < 0 {
+		return errors.New("lobby player counts must not be negative")
+	}
+	if r.Lobby.MaxPlayers > len(r.Locations) || r.minPlayers() > len(r.Locations) {
+		return fmt.Errorf("lobby player counts must not exceed the %d locations", len(r.Locations))
+	}
+	if r.Lobby.MaxPlayers > 0 && r.minPlayers() > r.Lobby.MaxPlayers {
+		return errors.New("lobby min_players must not exceed max_players")
+	}
+	return nil
+}
+
+func (r Rules) hasLocation(loc Location) bool {
+	_, ok := r.location(loc)
+	return ok
+}
+
+func (r Rules) location(loc Location) (LocationRule, bool) {
+	for _, l := range r.Locations {
+		if l.Name == loc {
+			return l, true
+		}
+	}
+	return LocationRule{}, false
+}
+
+func (r Rules) isAdjacent(from, to Location) bool {
+	if from == to {
+		return true
+	}
+	l, ok := r.location(from)
+	if !ok {
+		return false
+	}
+	return containsLocation(l.Adjacent, to)
+}
+
+func (r Rules) rank(rank UnitRank) (RankRule, bool) {
+	for _, rr := range r.Ranks {
+		if rr.Name == rank {
+			return rr, true
+		}
+	}
+	return RankRule{}, false
+}
+
+func (r Rules) hasRank(rank UnitRank) bool {
+	_, ok := r.rank(rank)
+	return ok
+}
+
+func (r Rules) power(rank UnitRank) int {
+	rr, _ := r.rank(rank)
+	return rr.Power
+}
+
+func (r Rules) powerLevel(units []Unit) int {
+	power := 0
+	for _, unit := range units {
+		power += r.power(unit.Rank)
+	}
+	return power
+}
+
+// effectiveness is the damage multiplier of attacker against target.
+func (r Rules) effectiveness(attacker, target UnitRank) float64 {
+	rr, _ := r.rank(attacker)
+	if mult, ok := rr.Effectiveness[target]; ok {
+		return mult
+	}
+	return 1
+}
+
+func (r Rules) maxRounds() int {
+	if r.Combat.MaxRounds == 0 {
+		return defaultMaxRounds
+	}
+	return r.Combat.MaxRounds
+}
+
+// TickInterval is how often the server sends economy ticks.
+func (r Rules) TickInterval() time.Duration {
+	if r.Economy.TickSeconds == 0 {
+		return defaultTickSeconds * time.Second
+	}
+	return time.Duration(r.Economy.TickSeconds) * time.Second
+}
+
+func (r Rules) minPlayers() int {
+	if r.Lobby.MinPlayers == 0 {
+		return defaultMinPlayers
+	}
+	return r.Lobby.MinPlayers
+}
+
+func containsLocation(locs []Location, loc Location) bool {
+	for _, l := range locs {
+		if l == loc {
+			return true
+		}
+	}
+	return false
+}
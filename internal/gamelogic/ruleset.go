@@ -0,0 +1,63 @@
+package gamelogic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// RulesetAnnouncement is published by the server on the retained ruleset
+// queue so every client plays with the same rules.
+type RulesetAnnouncement struct {
+	Version int
+	Hash    string
+	Rules   Rules
+}
+
+// Hash returns a content hash of the rules, used to detect clients that are
+// playing with a different ruleset.
+func (r Rules) Hash() string {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func NewRulesetAnnouncement(rules Rules) RulesetAnnouncement {
+	return RulesetAnnouncement{
+		Version: rules.Version,
+		Hash:    rules.Hash(),
+		Rules:   rules,
+	}
+}
+
+// AdoptRules checks the server's announced ruleset and returns the rules the
+// client should play with. If the player asked for specific local rules they
+// must match the server's exactly.
+func AdoptRules(announced RulesetAnnouncement, local *Rules) (Rules, error) {
+	if announced.Version != RulesVersion || announced.Rules.Version != RulesVersion {
+		return Rules{}, fmt.Errorf("the server uses rules version %d but this client only supports version %d, please upgrade", announced.Version, RulesVersion)
+	}
+	if announced.Rules.Hash() != announced.Hash {
+		return Rules{}, fmt.Errorf("the server's ruleset is corrupt (hash mismatch)")
+	}
+	err := announced.Rules.Validate()
+	if err != nil {
+		return Rules{}, fmt.Errorf("the server's ruleset is invalid: %v", err)
+	}
+	if local != nil && local.Hash() != announced.Hash {
+		return Rules{}, fmt.Errorf("your rules file %q (%s) does not match the server's ruleset %q (%s)",
+			local.Name, shortHash(local.Hash()), announced.Rules.Name, shortHash(announced.Hash))
+	}
+	return announced.Rules, nil
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
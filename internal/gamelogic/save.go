@@ -0,0 +1,178 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+const SaveVersion = 1
+
+// Save is everything a client needs to pick up where it left off. It is
+// written after every change to the game state and when the client stops.
+type Save struct {
+	Version    int
+	GameID     string
+	SavedAt    time.Time
+	Player     Player
+	Paused     bool
+	Over       bool
+	NextUnitID int
+	Treasury   Treasury
+}
+
+// SessionRequest asks the server what it knows about a returning player.
+// Proof is the player's signature over SessionMessage.
+type SessionRequest struct {
+	Username string
+	Proof    []byte
+}
+
+// SessionResponse is the server's view of a player. Known is false if the
+// server has never seen them play. Error is empty on success. Pause is the
+// game's last pause state, which tells the client how new a pause state has
+// to be to be believed.
+type SessionResponse struct {
+	Error    string
+	Known    bool
+	Player   Player
+	Owners   map[Location]string
+	Treasury Treasury
+	Pause    routing.PlayingState
+}
+
+// SessionMessage is what a player signs to prove a session request for a
+// game is theirs.
+func SessionMessage(gameID, username string) []byte {
+	return []byte("session " + gameID + " " + username)
+}
+
+// SavePath is where a player's save for a game lives unless another path is
+// given.
+func SavePath(gameID, username string) string {
+	return fmt.Sprintf("peril-%s-%s.save.json", gameID, username)
+}
+
+// AutoSave makes the game state save itself to path after every change.
+func (gs *GameState) AutoSave(gameID, path string) {
+	gs.saveMu.Lock()
+	defer gs.saveMu.Unlock()
+	gs.saveGameID = gameID
+	gs.savePath = path
+}
+
+// changed is deferred by every method that changes saved state, before it
+// takes the lock, so it runs once the lock is released.
+func (gs *GameState) changed() {
+	err := gs.Save()
+	if err != nil {
+		log.Printf("Failed to save game: %v\n", err)
+	}
+}
+
+// Save writes the game state to its save file, if saving is enabled. The
+// file is replaced atomically so a crash never leaves half a save behind.
+func (gs *GameState) Save() error {
+	gs.saveMu.Lock()
+	defer gs.saveMu.Unlock()
+	if gs.savePath == "" {
+		return nil
+	}
+
+	gs.mu.RLock()
+	save := Save{
+		Version:    SaveVersion,
+		GameID:     gs.saveGameID,
+		SavedAt:    time.Now(),
+		Paused:     gs.Paused,
+		Over:       gs.Over,
+		NextUnitID: gs.NextUnitID,
+		Treasury:   gs.Treasury,
+	}
+	gs.mu.RUnlock()
+	save.Player = gs.GetPlayerSnap()
+
+	data, err := json.MarshalIndent(save, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed JSON marshal save: %v", err)
+	}
+	tmp := gs.savePath + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, gs.savePath)
+}
+
+// LoadSave reads a save file. The returned bool is false if there is none.
+func LoadSave(path string) (Save, bool, error) {
+	var save Save
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return save, false, nil
+	}
+	if err != nil {
+		return save, false, err
+	}
+	err = json.Unmarshal(data, &save)
+	if err != nil {
+		return save, false, fmt.Errorf("failed JSON unmarshal save: %v", err)
+	}
+	if save.Version != SaveVersion {
+		return save, false, fmt.Errorf("save is version %d, but this client reads version %d", save.Version, SaveVersion)
+	}
+	return save, true, nil
+}
+
+// Resume restores a save made under the same username. When the server
+// knows the player, its view of their units wins, since battles may have
+// been fought while they were away, and its view of who owns what and of
+// their treasury replaces ours. The server's pause state is applied with
+// HandlePause.
+func (gs *GameState) Resume(save Save, session *SessionResponse) error {
+	if save.Player.Username != gs.GetUsername() {
+		return fmt.Errorf("save belongs to %s", save.Player.Username)
+	}
+	defer gs.changed()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	gs.Player = save.Player
+	if gs.Player.Units == nil {
+		gs.Player.Units = map[int]Unit{}
+	}
+	gs.Paused = save.Paused
+	gs.Over = save.Over
+	gs.NextUnitID = save.NextUnitID
+	gs.Treasury = save.Treasury
+	fmt.Printf("Resumed your game from %s with %d units and %d gold.\n",
+		save.SavedAt.Format(time.DateTime), len(save.Player.Units), save.Treasury.Gold)
+
+	if session == nil || !session.Known {
+		return nil
+	}
+	lost := 0
+	for id := range save.Player.Units {
+		if _, ok := session.Player.Units[id]; !ok {
+			lost++
+		}
+	}
+	gs.Player.Units = map[int]Unit{}
+	for id, unit := range session.Player.Units {
+		gs.Player.Units[id] = unit
+		gs.NextUnitID = max(gs.NextUnitID, id+1)
+	}
+	for loc, owner := range session.Owners {
+		gs.owners[loc] = owner
+	}
+	gs.Treasury = session.Treasury
+	if lost > 0 {
+		fmt.Printf("You lost %d unit(s) while you were away.\n", lost)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+const defaultPauseReason = "paused by the server"
+
+// PauseWarnings are how long before a scheduled pause clients are warned.
+var PauseWarnings = []time.Duration{time.Minute, 30 * time.Second, 10 * time.Second}
+
+// PauseWindow is a pause that starts at Start and lasts Duration, or until
+// the server resumes the game if Duration is 0. Recurring windows come back
+// every Every.
+type PauseWindow struct {
+	Start    time.Time
+	Duration time.Duration
+	Every    time.Duration
+	Reason   string
+}
+
+// PauseSchedule is the server's list of upcoming pauses for a game. Every
+// change is written to its file, so scheduled pauses survive a restart.
+type PauseSchedule struct {
+	path    string
+	windows []PauseWindow
+	mu      *sync.Mutex
+}
+
+// PauseSchedulePath is where the pause schedule of a game lives in dir.
+func PauseSchedulePath(dir, gameID string) string {
+	return filepath.Join(dir, gameID+".pauses.json")
+}
+
+// LoadPauseSchedule reads the pause schedule at path. A missing file is an
+// empty schedule.
+func LoadPauseSchedule(path string) (*PauseSchedule, error) {
+	s := &PauseSchedule{
+		path:    path,
+		windows: []PauseWindow{},
+		mu:      &sync.Mutex{},
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &s.windows)
+	if err != nil {
+		return nil, fmt.Errorf("failed JSON unmarshal pause schedule: %v", err)
+	}
+	s.sort()
+	return s, nil
+}
+
+func (s *PauseSchedule) Add(w PauseWindow) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.windows = append(s.windows, w)
+	s.sort()
+	return s.save()
+}
+
+func (s *PauseSchedule) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.windows = []PauseWindow{}
+	return s.save()
+}
+
+// Remove deletes the schedule's file, for a game that is closed.
+func (s *PauseSchedule) Remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.windows = []PauseWindow{}
+	err := os.Remove(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Windows returns the upcoming pauses, soonest first.
+func (s *PauseSchedule) Windows() []PauseWindow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PauseWindow{}, s.windows...)
+}
+
+// Next returns the soonest upcoming pause.
+func (s *PauseSchedule) Next() (PauseWindow, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.windows) == 0 {
+		return PauseWindow{}, false
+	}
+	return s.windows[0], true
+}
+
+// Due returns the pause that should start at now, if any. One-off pauses are
+// removed from the schedule and recurring ones move on to their next start.
+// A pause that fell due while the server was down is returned as soon as it
+// is back. The error is from saving the schedule, and the pause is due even
+// when it is set.
+func (s *PauseSchedule) Due(now time.Time) (PauseWindow, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.windows) == 0 || s.windows[0].Start.After(now) {
+		return PauseWindow{}, false, nil
+	}
+	due := s.windows[0]
+	if due.Every > 0 {
+		for !s.windows[0].Start.After(now) {
+			s.windows[0].Start = s.windows[0].Start.Add(due.Every)
+		}
+	} else {
+		s.windows = s.windows[1:]
+	}
+	s.sort()
+	return due, true, s.save()
+}
+
+func (s *PauseSchedule) save() error {
+	data, err := json.MarshalIndent(s.windows, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed JSON marshal pause schedule: %v", err)
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *PauseSchedule) sort() {
+	sort.SliceStable(s.windows, func(i, j int) bool {
+		return s.windows[i].Start.Before(s.windows[j].Start)
+	})
+}
+
+// ResumeAt is when a pause starting at start ends, or the zero time if it
+// lasts until the server resumes the game.
+func (w PauseWindow) ResumeAt(start time.Time) time.Time {
+	if w.Duration == 0 {
+		return time.Time{}
+	}
+	return start.Add(w.Duration)
+}
+
+func (w PauseWindow) String() string {
+	when := w.Start.Format(time.DateTime)
+	if w.Every == 24*time.Hour {
+		when = "every day at " + w.Start.Format("15:04")
+	}
+	length := "until resumed"
+	if w.Duration > 0 {
+		length = "for " + w.Duration.String()
+	}
+	return fmt.Sprintf("%s %s: %s", when, length, w.Reason)
+}
+
+// ParsePause reads the server's pause command:
+//
+//	pause [duration] [reason...]
+//	pause at <hh:mm> [duration] [reason...]
+//	pause every <hh:mm> <duration> [reason...]
+//
+// A pause without `at` or `every` starts now.
+func ParsePause(words []string, now time.Time) (PauseWindow, error) {
+	w := PauseWindow{Start: now}
+	rest := words[1:]
+
+	if len(rest) > 0 && (rest[0] == "at" || rest[0] == "every") {
+		if len(rest) < 2 {
+			return PauseWindow{}, fmt.Errorf("usage: pause %s <hh:mm> [duration] [reason]", rest[0])
+		}
+		start, err := nextClockTime(rest[1], now)
+		if err != nil {
+			return PauseWindow{}, err
+		}
+		w.Start = start
+		if rest[0] == "every" {
+			w.Every = 24 * time.Hour
+		}
+		rest = rest[2:]
+	}
+
+	if len(rest) > 0 {
+		d, err := time.ParseDuration(rest[0])
+		if err == nil {
+			if d <= 0 {
+				return PauseWindow{}, errors.New("a pause must last longer than 0s")
+			}
+			w.Duration = d
+			rest = rest[1:]
+		}
+	}
+	if w.Every > 0 && w.Duration == 0 {
+		return PauseWindow{}, errors.New("a recurring pause needs a duration")
+	}
+
+	w.Reason = strings.Join(rest, " ")
+	if w.Reason == "" {
+		w.Reason = defaultPauseReason
+	}
+	return w, nil
+}
+
+// nextClockTime is the next time the clock shows hh:mm, today or tomorrow.
+func nextClockTime(clock string, now time.Time) (time.Time, error) {
+	t, err := time.ParseInLocation("15:04", clock, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad time %q, use hh:mm", clock)
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next, nil
+}
+
+// PrintPauseStatus prints whether a game is paused and its upcoming pauses.
+func PrintPauseStatus(state routing.PlayingState, windows []PauseWindow) {
+	switch {
+	case state.IsPaused && state.ResumeAt.IsZero():
+		fmt.Printf("The game is paused: %s.\n", state.Reason)
+	case state.IsPaused:
+		fmt.Printf("The game is paused until %s: %s.\n", state.ResumeAt.Format(time.TimeOnly), state.Reason)
+	default:
+		fmt.Println("The game is running.")
+	}
+	fmt.Println("Scheduled pauses:")
+	if len(windows) == 0 {
+		fmt.Println("* none")
+	}
+	for _, w := range windows {
+		fmt.Printf("* %v\n", w)
+	}
+}
@@ -0,0 +1,111 @@
+package gamelogic
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePause(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		command string
+		want    PauseWindow
+		wantErr bool
+	}{
+		{"now", "pause", PauseWindow{Start: now, Reason: defaultPauseReason}, false},
+		{"now for a while", "pause 5m lunch break", PauseWindow{Start: now, Duration: 5 * time.Minute, Reason: "lunch break"}, false},
+		{"reason only", "pause lunch", PauseWindow{Start: now, Reason: "lunch"}, false},
+		{"later today", "pause at 13:30 10m", PauseWindow{
+			Start: time.Date(2025, 1, 1, 13, 30, 0, 0, time.UTC), Duration: 10 * time.Minute, Reason: defaultPauseReason,
+		}, false},
+		{"tomorrow", "pause at 11:00", PauseWindow{
+			Start: time.Date(2025, 1, 2, 11, 0, 0, 0, time.UTC), Reason: defaultPauseReason,
+		}, false},
+		{"every day", "pause every 12:00 1h maintenance", PauseWindow{
+			Start: time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC), Duration: time.Hour, Every: 24 * time.Hour, Reason: "maintenance",
+		}, false},
+		{"every day without a duration", "pause every 12:00", PauseWindow{}, true},
+		{"at without a time", "pause at", PauseWindow{}, true},
+		{"bad time", "pause at noon", PauseWindow{}, true},
+		{"zero duration", "pause 0s", PauseWindow{}, true},
+		{"negative duration", "pause -5m", PauseWindow{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePause(strings.Fields(tt.command), now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePause(%q) returned %v, want error %v", tt.command, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParsePause(%q) = %+v, want %+v", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPauseScheduleDue(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	daily := PauseWindow{Start: start, Duration: time.Hour, Every: 24 * time.Hour, Reason: "daily"}
+	once := PauseWindow{Start: start.Add(time.Hour), Reason: "once"}
+	tests := []struct {
+		name string
+		now  time.Time
+		// due are the reasons of the pauses due at now, in the order Due
+		// returns them
+		due  []string
+		next []time.Time
+	}{
+		{"nothing due yet", start.Add(-time.Second), nil, []time.Time{start, start.Add(time.Hour)}},
+		{"recurring pause moves on", start, []string{"daily"}, []time.Time{start.Add(time.Hour), start.Add(24 * time.Hour)}},
+		{"one-off pause is removed", start.Add(time.Hour), []string{"daily", "once"}, []time.Time{start.Add(24 * time.Hour)}},
+		{"missed days are skipped", start.Add(72 * time.Hour), []string{"daily", "once"}, []time.Time{start.Add(96 * time.Hour)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.pauses.json")
+			s, err := LoadPauseSchedule(path)
+			if err != nil {
+				t.Fatalf("LoadPauseSchedule: %v", err)
+			}
+			s.Add(once)
+			s.Add(daily)
+
+			got := []string{}
+			for {
+				due, ok, err := s.Due(tt.now)
+				if err != nil {
+					t.Fatalf("Due: %v", err)
+				}
+				if !ok {
+					break
+				}
+				got = append(got, due.Reason)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.due, ",") {
+				t.Fatalf("the pauses due are %v, want %v", got, tt.due)
+			}
+
+			// The schedule is read back as it was left
+			s, err = LoadPauseSchedule(path)
+			if err != nil {
+				t.Fatalf("LoadPauseSchedule: %v", err)
+			}
+			next := []time.Time{}
+			for _, w := range s.Windows() {
+				next = append(next, w.Start)
+			}
+			if len(next) != len(tt.next) {
+				t.Fatalf("the schedule starts at %v, want %v", next, tt.next)
+			}
+			for i := range next {
+				if !next[i].Equal(tt.next[i]) {
+					t.Errorf("the schedule starts at %v, want %v", next, tt.next)
+					break
+				}
+			}
+		})
+	}
+}
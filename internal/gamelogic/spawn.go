@@ -5,30 +5,84 @@ import (
 	"fmt"
 )
 
-func (gs *GameState) CommandSpawn(words []string) error {
+func (gs *GameState) CommandSpawn(words []string) (UnitSpawn, error) {
+	if gs.isOver() {
+		return UnitSpawn{}, errors.New("the game is over, you can not spawn units")
+	}
 	if len(words) < 3 {
-		return errors.New("usage: spawn <location> <rank>")
+		return UnitSpawn{}, errors.New("usage: spawn <location> <rank>")
 	}
 
 	locationName := words[1]
-	locations := getAllLocations()
-	if _, ok := locations[Location(locationName)]; !ok {
-		return fmt.Errorf("error: %s is not a valid location", locationName)
+	if !gs.Rules.hasLocation(Location(locationName)) {
+		return UnitSpawn{}, fmt.Errorf("error: %s is not a valid location", locationName)
 	}
 
 	rank := words[2]
-	units := getAllRanks()
-	if _, ok := units[UnitRank(rank)]; !ok {
-		return fmt.Errorf("error: %s is not a valid unit", rank)
+	if !gs.Rules.hasRank(UnitRank(rank)) {
+		return UnitSpawn{}, fmt.Errorf("error: %s is not a valid unit", rank)
+	}
+
+	units := gs.getUnitsSnap()
+	if max := gs.Rules.Limits.MaxUnits; max > 0 && len(units) >= max {
+		return UnitSpawn{}, fmt.Errorf("error: you already have the maximum of %d units", max)
+	}
+	if max := gs.Rules.Limits.MaxUnitsPerLocation; max > 0 && countUnitsIn(units, Location(locationName)) >= max {
+		return UnitSpawn{}, fmt.Errorf("error: %s already holds the maximum of %d of your units", locationName, max)
+	}
+
+	if gs.Rules.Limits.SpawnOnlyInOwned {
+		owned := gs.ownedLocations()
+		if len(owned) > 0 && !containsLocation(owned, Location(locationName)) {
+			return UnitSpawn{}, errors.New("error: you can only spawn units in locations you own")
+		}
+		if len(owned) == 0 && gs.ownerOf(Location(locationName)) != "" {
+			return UnitSpawn{}, fmt.Errorf("error: %s is owned by %s", locationName, gs.ownerOf(Location(locationName)))
+		}
+	}
+
+	rr, _ := gs.Rules.rank(UnitRank(rank))
+	err := gs.spend(rr.Cost, fmt.Sprintf("spawning a(n) %s", rank))
+	if err != nil {
+		return UnitSpawn{}, err
 	}
 
-	id := len(gs.getUnitsSnap()) + 1
-	gs.addUnit(Unit{
+	id := gs.nextUnitID()
+	unit := Unit{
 		ID:       id,
 		Rank:     UnitRank(rank),
 		Location: Location(locationName),
-	})
+	}
+	gs.addUnit(unit)
 
 	fmt.Printf("Spawned a(n) %s in %s with id %v\n", rank, locationName, id)
-	return nil
+	return UnitSpawn{
+		Player: gs.GetPlayerSnap(),
+		Unit:   unit,
+	}, nil
+}
+
+func countUnitsIn(units []Unit, loc Location) int {
+	count := 0
+	for _, unit := range units {
+		if unit.Location == loc {
+			count++
+		}
+	}
+	return count
+}
+
+// CancelSpawn takes back a spawn that could not be published: the unit is
+// removed and its cost refunded.
+func (gs *GameState) CancelSpawn(spawn UnitSpawn) {
+	defer gs.changed()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	current, ok := gs.Player.Units[spawn.Unit.ID]
+	if !ok || current.Location != spawn.Unit.Location {
+		return
+	}
+	delete(gs.Player.Units, spawn.Unit.ID)
+	rr, _ := gs.Rules.rank(spawn.Unit.Rank)
+	gs.Treasury.Gold += rr.Cost
 }
@@ -0,0 +1,64 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+)
+
+func (gs *GameState) HandleOwnership(change OwnershipChange) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Territory Changed ====")
+	fmt.Println(change)
+
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if change.Owner == "" {
+		delete(gs.owners, change.Location)
+	} else {
+		gs.owners[change.Location] = change.Owner
+	}
+}
+
+// ownedLocations returns the locations the server says we own.
+func (gs *GameState) ownedLocations() []Location {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	owned := []Location{}
+	for loc, owner := range gs.owners {
+		if owner == gs.Player.Username {
+			owned = append(owned, loc)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i] < owned[j]
+	})
+	return owned
+}
+
+func (gs *GameState) ownerOf(loc Location) string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.owners[loc]
+}
+
+// CommandWorld prints every location's owner and the garrisons we know of,
+// our own units plus the last known units of every other player.
+func (gs *GameState) CommandWorld() {
+	players := append([]Player{gs.GetPlayerSnap()}, gs.getOthersSnap()...)
+	territories := []Territory{}
+	for _, loc := range gs.Rules.Locations {
+		t := Territory{
+			Location: loc.Name,
+			Owner:    gs.ownerOf(loc.Name),
+			Garrison: map[string]int{},
+		}
+		for _, p := range players {
+			if power := gs.Rules.powerLevel(unitsInLocation(p, loc.Name)); power > 0 {
+				t.Garrison[p.Username] = power
+			}
+		}
+		territories = append(territories, t)
+	}
+	PrintTerritories(territories)
+}
@@ -0,0 +1,26 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+)
+
+const MaxUsernameLength = 24
+
+// ValidateUsername accepts 1 to MaxUsernameLength letters, digits, '-' and
+// '_'. Usernames end up in routing keys and queue names, and anything else
+// could change how they match, so it is refused.
+func ValidateUsername(username string) error {
+	if username == "" {
+		return errors.New("username can not be empty")
+	}
+	if len(username) > MaxUsernameLength {
+		return fmt.Errorf("username can be at most %d characters", MaxUsernameLength)
+	}
+	for _, r := range username {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("username %q may only contain letters, digits, '-' and '_'", username)
+		}
+	}
+	return nil
+}
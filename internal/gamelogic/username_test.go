@@ -0,0 +1,32 @@
+package gamelogic
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		ok       bool
+	}{
+		{"letters and digits", "Alice01", true},
+		{"dash and underscore", "a-b_c", true},
+		{"longest", strings.Repeat("a", MaxUsernameLength), true},
+		{"empty", "", false},
+		{"too long", strings.Repeat("a", MaxUsernameLength+1), false},
+		{"dot", "a.b", false},
+		{"wildcard", "a*", false},
+		{"space", "a b", false},
+		{"unicode", "é", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUsername(tt.username)
+			if (err == nil) != tt.ok {
+				t.Errorf("ValidateUsername(%q) = %v, want ok %v", tt.username, err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GameOver is broadcast by the server once a victory condition is met.
+// Winner is empty when the game ends in a tie.
+type GameOver struct {
+	Winner    string
+	Reason    string
+	EndedAt   time.Time
+	Standings []Standing
+}
+
+type Standing struct {
+	Username   string
+	Locations  int
+	Power      int
+	Score      int
+	Eliminated bool
+}
+
+// pointsPerLocation is how much owning a location is worth in the score,
+// compared to one point per unit of power.
+const pointsPerLocation = 10
+
+// Standings ranks every player by score, highest first.
+func (w *World) Standings() []Standing {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.standings()
+}
+
+func (w *World) standings() []Standing {
+	owned := map[string]int{}
+	for _, owner := range w.Owners {
+		if owner != "" {
+			owned[owner]++
+		}
+	}
+
+	standings := []Standing{}
+	for username, p := range w.Players {
+		power := 0
+		for _, unit := range p.Units {
+			power += w.Rules.power(unit.Rank)
+		}
+		standings = append(standings, Standing{
+			Username:   username,
+			Locations:  owned[username],
+			Power:      power,
+			Score:      owned[username]*pointsPerLocation + power,
+			Eliminated: owned[username] == 0 && len(p.Units) == 0,
+		})
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].Score != standings[j].Score {
+			return standings[i].Score > standings[j].Score
+		}
+		return standings[i].Username < standings[j].Username
+	})
+	return standings
+}
+
+// CheckVictory reports whether a victory condition has been met. It does not
+// end the game: that takes applying the game over event, which freezes the
+// world, and a world that is over reports no victory.
+func (w *World) CheckVictory(now time.Time) (GameOver, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.over {
+		return GameOver{}, false
+	}
+
+	standings := w.standings()
+	over := GameOver{
+		EndedAt:   now,
+		Standings: standings,
+	}
+
+	// Locations only count once someone else is playing to contest them
+	if n := w.Rules.Victory.ControlLocations; n > 0 && len(standings) > 1 {
+		for _, s := range standings {
+			if s.Locations >= n {
+				over.Winner = s.Username
+				over.Reason = fmt.Sprintf("by controlling %d locations", s.Locations)
+				break
+			}
+		}
+	}
+
+	if over.Winner == "" && w.Rules.Victory.EliminateAll && len(standings) > 1 {
+		alive := []string{}
+		for _, s := range standings {
+			if !s.Eliminated {
+				alive = append(alive, s.Username)
+			}
+		}
+		if len(alive) == 1 {
+			over.Winner = alive[0]
+			over.Reason = "by eliminating all opponents"
+		}
+	}
+
+	limit := time.Duration(w.Rules.Victory.TimeLimitSeconds) * time.Second
+	if over.Winner == "" && limit > 0 && now.Sub(w.StartedAt) >= limit {
+		over.Reason = "the time limit was reached with tied scores"
+		if len(standings) > 0 && (len(standings) == 1 || standings[0].Score > standings[1].Score) {
+			over.Winner = standings[0].Username
+			over.Reason = "with the highest score when the time limit was reached"
+		}
+		return over, true
+	}
+
+	if over.Winner == "" {
+		return GameOver{}, false
+	}
+	return over, true
+}
+
+func (w *World) IsOver() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.over
+}
+
+func (g GameOver) Summary() string {
+	var result string
+	if g.Winner == "" {
+		result = fmt.Sprintf("Game over: the game ended in a tie because %s.", g.Reason)
+	} else {
+		result = fmt.Sprintf("Game over: %s wins %s.", g.Winner, g.Reason)
+	}
+	places := []string{}
+	for i, s := range g.Standings {
+		places = append(places, fmt.Sprintf("%d. %s %d (%d locations, %d power)", i+1, s.Username, s.Score, s.Locations, s.Power))
+	}
+	return fmt.Sprintf("%s Final standings: %s", result, strings.Join(places, ", "))
+}
+
+func PrintStandings(standings []Standing) {
+	fmt.Println("Standings:")
+	for i, s := range standings {
+		status := ""
+		if s.Eliminated {
+			status = " (eliminated)"
+		}
+		fmt.Printf("%d. %s: %d points, %d location(s), %d power%s\n", i+1, s.Username, s.Score, s.Locations, s.Power, status)
+	}
+}
+
+func (gs *GameState) HandleGameOver(over GameOver) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Game Over ====")
+	switch over.Winner {
+	case "":
+		fmt.Printf("The game ended in a tie because %s.\n", over.Reason)
+	case gs.GetUsername():
+		fmt.Printf("You win %s!\n", over.Reason)
+	default:
+		fmt.Printf("%s wins %s.\n", over.Winner, over.Reason)
+	}
+	PrintStandings(over.Standings)
+
+	defer gs.changed()
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Over = true
+}
+
+func (gs *GameState) isOver() bool {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.Over
+}
@@ -2,6 +2,8 @@ package gamelogic
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type WarOutcome int
@@ -14,92 +16,195 @@ const (
 	WarOutcomeDraw
 )
 
-func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
-	defer fmt.Println("------------------------")
-	fmt.Println()
-	fmt.Println("==== War Declared ====")
-	fmt.Printf("%s has declared war on %s!\n", rw.Attacker.Username, rw.Defender.Username)
+// FormSides groups the players in a location into sides. Players that are
+// mutually allied fight together; everyone else fights alone.
+func FormSides(players []Player) []BattleSide {
+	sorted := append([]Player{}, players...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Username < sorted[j].Username
+	})
 
-	player := gs.GetPlayerSnap()
-
-	if player.Username == rw.Defender.Username {
-		fmt.Printf("%s, you published the war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+	side := make([]int, len(sorted))
+	for i := range sorted {
+		side[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if side[i] != i {
+			side[i] = find(side[i])
+		}
+		return side[i]
+	}
+	for i := range sorted {
+		for j := i + 1; j < len(sorted); j++ {
+			if isAllied(sorted[i], sorted[j]) {
+				side[find(j)] = find(i)
+			}
+		}
 	}
 
-	if player.Username != rw.Attacker.Username {
-		fmt.Printf("%s, you are not involved in this war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+	sides := []BattleSide{}
+	index := map[int]int{}
+	for i, p := range sorted {
+		root := find(i)
+		if _, ok := index[root]; !ok {
+			index[root] = len(sides)
+			sides = append(sides, BattleSide{})
+		}
+		sides[index[root]].Players = append(sides[index[root]].Players, p)
 	}
+	return sides
+}
 
-	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
-	if overlappingLocation == "" {
-		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
-		return WarOutcomeNoUnits, "", ""
+// atWar reports whether the mover's units now share loc with ours and are
+// not on our side. It is only our view: the server declares the battle.
+func (gs *GameState) atWar(mover Player, loc Location) bool {
+	me := gs.GetPlayerSnap()
+	if len(unitsInLocation(me, loc)) == 0 {
+		return false
 	}
+	return !sameSide(FormSides([]Player{me, mover}), me.Username, mover.Username)
+}
 
-	attackerUnits := []Unit{}
-	defenderUnits := []Unit{}
-	for _, unit := range rw.Attacker.Units {
-		if unit.Location == overlappingLocation {
-			attackerUnits = append(attackerUnits, unit)
-		}
+// DeclareBattle returns the battle a move starts in the location it moved
+// to, if the players there are on more than one side. Only the server
+// declares battles, since it is the only one that has seen every player's
+// units. The battle's ID and seed come from the move, so declaring it again
+// for the same move gives the same battle.
+func (w *World) DeclareBattle(move ArmyMove) (Battle, bool) {
+	id := fmt.Sprintf("%s-%s-%s", move.ToLocation, move.Player.Username, move.ID)
+	return w.declareBattle(id, move.ToLocation, move.Player.Username)
+}
+
+// DeclareSpawnBattle is DeclareBattle for a unit spawned into a location
+// held by another side. The spawner is the aggressor.
+func (w *World) DeclareSpawnBattle(spawn UnitSpawn) (Battle, bool) {
+	id := fmt.Sprintf("%s-%s-spawn%d", spawn.Unit.Location, spawn.Player.Username, spawn.Unit.ID)
+	return w.declareBattle(id, spawn.Unit.Location, spawn.Player.Username)
+}
+
+func (w *World) declareBattle(id string, loc Location, aggressor string) (Battle, bool) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.over {
+		return Battle{}, false
+	}
+	usernames := []string{}
+	for username := range w.Players {
+		usernames = append(usernames, username)
 	}
-	for _, unit := range rw.Defender.Units {
-		if unit.Location == overlappingLocation {
-			defenderUnits = append(defenderUnits, unit)
+	sort.Strings(usernames)
+	participants := []Player{}
+	for _, username := range usernames {
+		p := w.Players[username]
+		if len(unitsInLocation(p, loc)) > 0 {
+			participants = append(participants, p)
 		}
 	}
+	sides := FormSides(participants)
+	if len(sides) < 2 {
+		return Battle{}, false
+	}
+
+	return Battle{
+		ID:        id,
+		Seed:      battleSeed(id),
+		Location:  loc,
+		Aggressor: aggressor,
+		Declarer:  ServerName,
+		Sides:     sides,
+	}, true
+}
 
-	fmt.Printf("%s's units:\n", rw.Attacker.Username)
-	for _, unit := range attackerUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
-	}
-	fmt.Printf("%s's units:\n", rw.Defender.Username)
-	for _, unit := range defenderUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
-	}
-	attackerPower := unitsToPowerLevel(attackerUnits)
-	defenderPower := unitsToPowerLevel(defenderUnits)
-	fmt.Printf("Attacker has a power level of %v\n", attackerPower)
-	fmt.Printf("Defender has a power level of %v\n", defenderPower)
-	if attackerPower > defenderPower {
-		fmt.Printf("%s has won the war!\n", rw.Attacker.Username)
-		if player.Username == rw.Defender.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Attacker.Username, rw.Defender.Username
+func sameSide(sides []BattleSide, a, b string) bool {
+	for _, side := range sides {
+		hasA, hasB := false, false
+		for _, p := range side.Players {
+			hasA = hasA || p.Username == a
+			hasB = hasB || p.Username == b
 		}
-		return WarOutcomeYouWon, rw.Attacker.Username, rw.Defender.Username
-	} else if defenderPower > attackerPower {
-		fmt.Printf("%s has won the war!\n", rw.Defender.Username)
-		if player.Username == rw.Attacker.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Defender.Username, rw.Attacker.Username
+		if hasA || hasB {
+			return hasA && hasB
 		}
-		return WarOutcomeYouWon, rw.Defender.Username, rw.Attacker.Username
 	}
-	fmt.Println("The war ended in a draw!")
-	fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-	gs.removeUnitsInLocation(overlappingLocation)
-	return WarOutcomeDraw, rw.Attacker.Username, rw.Defender.Username
+	return false
 }
 
-func unitsToPowerLevel(units []Unit) int {
-	power := 0
-	for _, unit := range units {
-		if unit.Rank == RankArtillery {
-			power += 10
+func (b Battle) involves(username string) bool {
+	for _, side := range b.Sides {
+		for _, p := range side.Players {
+			if p.Username == username {
+				return true
+			}
 		}
-		if unit.Rank == RankCavalry {
-			power += 5
+	}
+	return false
+}
+
+func (gs *GameState) HandleBattle(b Battle) (WarOutcome, BattleReport) {
+	player := gs.GetPlayerSnap()
+
+	if !b.involves(player.Username) {
+		fmt.Println()
+		fmt.Printf("%s, you are not involved in the war in %s.\n", player.Username, b.Location)
+		return WarOutcomeNotInvolved, BattleReport{}
+	}
+	if !gs.markFought(b.ID) {
+		return WarOutcomeNotInvolved, BattleReport{}
+	}
+
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== War Declared ====")
+	fmt.Printf("%s has started a war in %s!\n", b.Aggressor, b.Location)
+
+	sidesWithUnits := 0
+	for _, side := range b.Sides {
+		names := []string{}
+		units := []Unit{}
+		for _, p := range side.Players {
+			names = append(names, p.Username)
+			units = append(units, unitsInLocation(p, b.Location)...)
+		}
+		if len(units) > 0 {
+			sidesWithUnits++
+		}
+		fmt.Printf("%s's units:\n", strings.Join(names, "+"))
+		for _, unit := range units {
+			fmt.Printf("  * %v\n", unit.Rank)
 		}
-		if unit.Rank == RankInfantry {
-			power += 1
+	}
+	if sidesWithUnits < 2 {
+		fmt.Printf("Error! No opposing units are in %s. No war will be fought.\n", b.Location)
+		return WarOutcomeNoUnits, BattleReport{}
+	}
+
+	report := ResolveBattle(gs.Rules, b)
+	report.Print()
+
+	for _, side := range report.Sides {
+		for _, username := range side.Players {
+			if username != player.Username {
+				gs.forgetUnits(username, report.CasualtiesOf(username))
+			}
 		}
 	}
-	return power
+	casualties := report.CasualtiesOf(player.Username)
+	gs.removeUnits(casualties)
+	if len(casualties) > 0 {
+		fmt.Printf("You lost %d unit(s) in %s.\n", len(casualties), b.Location)
+	}
+
+	switch {
+	case len(report.Winners) == 0:
+		fmt.Println("The war ended in a draw!")
+		return WarOutcomeDraw, report
+	case report.Won(player.Username):
+		fmt.Printf("%s has won the war!\n", strings.Join(report.Winners, "+"))
+		return WarOutcomeYouWon, report
+	default:
+		fmt.Printf("%s has won the war!\n", strings.Join(report.Winners, "+"))
+		fmt.Println("You have lost the war!")
+		return WarOutcomeOpponentWon, report
+	}
 }
@@ -0,0 +1,395 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// World is the server's view of every player and who owns each location. It
+// is kept up to date from the spawns, moves and battles clients publish,
+// each checked against the rules and the player's treasury first.
+type World struct {
+	Rules   Rules
+	Players map[string]Player
+	Owners  map[Location]string
+	// Treasuries is each player's gold as the server counts it, which is
+	// what their spawns and moves are paid from
+	Treasuries map[string]Treasury
+	// CreatedAt is when the game was created. Unlike StartedAt, it never
+	// moves, so the economy counts its ticks from it
+	CreatedAt time.Time
+	StartedAt time.Time
+	// Pause is the last pause state, kept for replays
+	Pause routing.PlayingState
+	// fought holds the ID of every battle applied, so a battle that is
+	// delivered twice only takes its casualties once
+	fought map[string]bool
+	// tick is the last economy tick applied
+	tick int
+	over bool
+	mu   *sync.RWMutex
+}
+
+// UnitSpawn is published by a client whenever it spawns a unit.
+type UnitSpawn struct {
+	Player Player
+	Unit   Unit
+}
+
+// OwnershipChange is broadcast by the server whenever a location changes
+// hands. Owner is empty if the location no longer has an owner.
+type OwnershipChange struct {
+	Location Location
+	Previous string
+	Owner    string
+}
+
+type Territory struct {
+	Location Location
+	Owner    string
+	// Garrison is the total power each player has in the location.
+	Garrison map[string]int
+}
+
+func NewWorld(rules Rules) *World {
+	return &World{
+		Rules:      rules,
+		Players:    map[string]Player{},
+		Owners:     map[Location]string{},
+		Treasuries: map[string]Treasury{},
+		CreatedAt:  time.Now(),
+		StartedAt:  time.Now(),
+		fought:     map[string]bool{},
+		mu:         &sync.RWMutex{},
+	}
+}
+
+// ApplySpawn adds the spawned unit to the player and charges its cost. Only
+// the player's alliances are taken from their snapshot: their units are the
+// server's, so a client can not hand itself units by publishing them.
+func (w *World) ApplySpawn(spawn UnitSpawn) []OwnershipChange {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.over {
+		return nil
+	}
+	p := w.player(spawn.Player)
+	p.Units[spawn.Unit.ID] = spawn.Unit
+	w.Players[p.Username] = p
+	rr, _ := w.Rules.rank(spawn.Unit.Rank)
+	w.charge(p.Username, rr.Cost)
+	return w.updateOwners(nil)
+}
+
+// ApplyMove moves the player's units that the move names and charges its
+// cost. Units the server does not know of are skipped.
+func (w *World) ApplyMove(move ArmyMove) []OwnershipChange {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.over {
+		return nil
+	}
+	p := w.player(move.Player)
+	for _, u := range move.Units {
+		if current, ok := p.Units[u.ID]; ok {
+			current.Location = move.ToLocation
+			p.Units[u.ID] = current
+		}
+	}
+	w.Players[p.Username] = p
+	w.charge(p.Username, w.moveCost(move))
+	return w.updateOwners(nil)
+}
+
+// player returns the server's copy of a player with the alliances from their
+// latest snapshot.
+func (w *World) player(snapshot Player) Player {
+	p := Player{
+		Username: snapshot.Username,
+		Units:    map[int]Unit{},
+		Allies:   append([]string{}, snapshot.Allies...),
+	}
+	for id, unit := range w.Players[snapshot.Username].Units {
+		p.Units[id] = unit
+	}
+	return p
+}
+
+// checkSpawn reports why a spawn breaks the rules or can not be paid for.
+func (w *World) checkSpawn(spawn UnitSpawn) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	username := spawn.Player.Username
+	unit := spawn.Unit
+	if !w.Rules.hasLocation(unit.Location) {
+		return fmt.Errorf("%s is not a valid location", unit.Location)
+	}
+	rr, ok := w.Rules.rank(unit.Rank)
+	if !ok {
+		return fmt.Errorf("%s is not a valid unit", unit.Rank)
+	}
+	units := w.Players[username].Units
+	if _, ok := units[unit.ID]; ok {
+		return fmt.Errorf("%s already has a unit %d", username, unit.ID)
+	}
+	if max := w.Rules.Limits.MaxUnits; max > 0 && len(units) >= max {
+		return fmt.Errorf("%s already has the maximum of %d units", username, max)
+	}
+	held := []Unit{}
+	for _, u := range units {
+		held = append(held, u)
+	}
+	if max := w.Rules.Limits.MaxUnitsPerLocation; max > 0 && countUnitsIn(held, unit.Location) >= max {
+		return fmt.Errorf("%s already holds the maximum of %d of %s's units", unit.Location, max, username)
+	}
+	if w.Rules.Limits.SpawnOnlyInOwned {
+		owned := false
+		for _, owner := range w.Owners {
+			owned = owned || owner == username
+		}
+		if owner := w.Owners[unit.Location]; owned && owner != username || !owned && owner != "" {
+			return fmt.Errorf("%s can not spawn in %s", username, unit.Location)
+		}
+	}
+	if gold := w.gold(username); rr.Cost > gold {
+		return fmt.Errorf("a(n) %s costs %d gold but %s only has %d", unit.Rank, rr.Cost, username, gold)
+	}
+	return nil
+}
+
+// checkMove reports why a move breaks the rules or can not be paid for.
+func (w *World) checkMove(move ArmyMove) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	username := move.Player.Username
+	if !w.Rules.hasLocation(move.ToLocation) {
+		return fmt.Errorf("%s is not a valid location", move.ToLocation)
+	}
+	if len(move.Units) == 0 {
+		return errors.New("the move has no units")
+	}
+	units := w.Players[username].Units
+	moving := map[int]struct{}{}
+	for _, u := range move.Units {
+		current, ok := units[u.ID]
+		if !ok {
+			return fmt.Errorf("%s has no unit %d", username, u.ID)
+		}
+		if !w.Rules.isAdjacent(current.Location, move.ToLocation) {
+			return fmt.Errorf("unit %d can not reach %s from %s", u.ID, move.ToLocation, current.Location)
+		}
+		moving[u.ID] = struct{}{}
+	}
+	if max := w.Rules.Limits.MaxUnitsPerLocation; max > 0 {
+		count := len(moving)
+		for id, u := range units {
+			if _, ok := moving[id]; !ok && u.Location == move.ToLocation {
+				count++
+			}
+		}
+		if count > max {
+			return fmt.Errorf("%s can hold at most %d of %s's units", move.ToLocation, max, username)
+		}
+	}
+	if cost, gold := w.moveCost(move), w.gold(username); cost > gold {
+		return fmt.Errorf("moving %d unit(s) costs %d gold but %s only has %d", len(move.Units), cost, username, gold)
+	}
+	return nil
+}
+
+// moveCost is what a move costs for the units the server knows it moves.
+func (w *World) moveCost(move ArmyMove) int {
+	cost := 0
+	for _, u := range move.Units {
+		if current, ok := w.Players[move.Player.Username].Units[u.ID]; ok {
+			rr, _ := w.Rules.rank(current.Rank)
+			cost += rr.MoveCost
+		}
+	}
+	return cost
+}
+
+// StartMatch hands every player their starting location and restarts the
+// game clock at at.
+func (w *World) StartMatch(start MatchStart, at time.Time) []OwnershipChange {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.StartedAt = at
+	changes := []OwnershipChange{}
+	for username, loc := range start.StartingLocations {
+		if previous := w.Owners[loc]; previous != username {
+			w.Owners[loc] = username
+			changes = append(changes, OwnershipChange{
+				Location: loc,
+				Previous: previous,
+				Owner:    username,
+			})
+		}
+	}
+	return changes
+}
+
+// ApplyBattle removes the casualties of a battle resolved with
+// ResolveBattle, exactly as the clients resolve it, and hands the location
+// to the winners if they hold it alone.
+func (w *World) ApplyBattle(report BattleReport) []OwnershipChange {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.over || w.fought[report.BattleID] {
+		return nil
+	}
+	w.fought[report.BattleID] = true
+	for username, p := range w.Players {
+		casualties := report.CasualtiesOf(username)
+		if len(casualties) == 0 {
+			continue
+		}
+		units := map[int]Unit{}
+		for k, v := range p.Units {
+			units[k] = v
+		}
+		for _, u := range casualties {
+			if current, ok := units[u.ID]; ok && current.Location == u.Location {
+				delete(units, u.ID)
+			}
+		}
+		p.Units = units
+		w.Players[username] = p
+	}
+
+	winners := map[Location][]string{report.Location: report.Winners}
+	return w.updateOwners(winners)
+}
+
+// updateOwners recomputes ownership of every location. A player that is the
+// only one with units in a location owns it. A contested location keeps its
+// owner unless a battle there was won by players who now hold it alone, in
+// which case the old owner keeps it if they were among the winners and the
+// first surviving winner by name takes it otherwise. Empty locations keep
+// their owner.
+func (w *World) updateOwners(winners map[Location][]string) []OwnershipChange {
+	changes := []OwnershipChange{}
+	for _, loc := range w.Rules.Locations {
+		occupants := w.occupants(loc.Name)
+		previous := w.Owners[loc.Name]
+		owner := previous
+
+		if len(occupants) == 1 {
+			owner = occupants[0]
+		} else if won, ok := winners[loc.Name]; ok && len(won) > 0 && len(occupants) > 0 && allIn(occupants, won) {
+			if !lists(occupants, previous) {
+				owner = occupants[0]
+			}
+		}
+
+		if owner != previous {
+			w.Owners[loc.Name] = owner
+			changes = append(changes, OwnershipChange{
+				Location: loc.Name,
+				Previous: previous,
+				Owner:    owner,
+			})
+		}
+	}
+	return changes
+}
+
+// occupants returns the players with units in loc, sorted by name.
+func (w *World) occupants(loc Location) []string {
+	occupants := []string{}
+	for username, p := range w.Players {
+		if len(unitsInLocation(p, loc)) > 0 {
+			occupants = append(occupants, username)
+		}
+	}
+	sort.Strings(occupants)
+	return occupants
+}
+
+func allIn(names, set []string) bool {
+	for _, name := range names {
+		if !lists(set, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Session is the server's view of a returning player.
+func (w *World) Session(username string) SessionResponse {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	owners := map[Location]string{}
+	for loc, owner := range w.Owners {
+		owners[loc] = owner
+	}
+	p, ok := w.Players[username]
+	return SessionResponse{
+		Known:    ok,
+		Player:   p,
+		Owners:   owners,
+		Treasury: w.treasury(username),
+	}
+}
+
+// PauseState is the last pause state recorded.
+func (w *World) PauseState() routing.PlayingState {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.Pause
+}
+
+func (w *World) Territories() []Territory {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	territories := []Territory{}
+	for _, loc := range w.Rules.Locations {
+		t := Territory{
+			Location: loc.Name,
+			Owner:    w.Owners[loc.Name],
+			Garrison: map[string]int{},
+		}
+		for username, p := range w.Players {
+			if power := w.Rules.powerLevel(unitsInLocation(p, loc.Name)); power > 0 {
+				t.Garrison[username] = power
+			}
+		}
+		territories = append(territories, t)
+	}
+	return territories
+}
+
+func PrintTerritories(territories []Territory) {
+	fmt.Println("World overview:")
+	for _, t := range territories {
+		owner := t.Owner
+		if owner == "" {
+			owner = "nobody"
+		}
+		fmt.Printf("* %s: owned by %s\n", t.Location, owner)
+		players := []string{}
+		for username := range t.Garrison {
+			players = append(players, username)
+		}
+		sort.Strings(players)
+		for _, username := range players {
+			fmt.Printf("    %s: %d power\n", username, t.Garrison[username])
+		}
+	}
+}
+
+func (c OwnershipChange) String() string {
+	switch {
+	case c.Previous == "":
+		return fmt.Sprintf("%s has taken %s", c.Owner, c.Location)
+	case c.Owner == "":
+		return fmt.Sprintf("%s has lost %s", c.Previous, c.Location)
+	default:
+		return fmt.Sprintf("%s has taken %s from %s", c.Owner, c.Location, c.Previous)
+	}
+}
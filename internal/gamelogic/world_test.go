@@ -0,0 +1,137 @@
+package gamelogic
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// testWorld is a game where alice has spawned one infantry in americas and
+// has 4 gold left. Americas only borders europe, and each player may have
+// at most 2 units.
+func testWorld(t *testing.T) (*World, *EventLog) {
+	t.Helper()
+	rules := DefaultRules()
+	rules.Economy.StartingGold = 5
+	rules.Limits.MaxUnits = 2
+	for i := range rules.Locations {
+		if rules.Locations[i].Name == "americas" {
+			rules.Locations[i].Adjacent = []Location{"europe"}
+		}
+	}
+
+	l, _, err := OpenEventLog(filepath.Join(t.TempDir(), "test.events.jsonl"), "test")
+	if err != nil {
+		t.Fatalf("OpenEventLog: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	w := NewWorld(rules)
+	_, _, err = l.Record(w, WorldEvent{Kind: EventCreate, Rules: &rules})
+	if err == nil {
+		_, _, err = l.Record(w, spawnEvent("alice", 1, RankInfantry, "americas"))
+	}
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	return w, l
+}
+
+func spawnEvent(username string, id int, rank UnitRank, loc Location) WorldEvent {
+	unit := Unit{ID: id, Rank: rank, Location: loc}
+	return WorldEvent{Kind: EventSpawn, Spawn: &UnitSpawn{
+		Player: Player{Username: username, Units: map[int]Unit{id: unit}},
+		Unit:   unit,
+	}}
+}
+
+func moveEvent(username string, loc Location, ids ...int) WorldEvent {
+	units := []Unit{}
+	for _, id := range ids {
+		units = append(units, Unit{ID: id, Location: loc})
+	}
+	return WorldEvent{Kind: EventMove, Move: &ArmyMove{
+		ID:         "m",
+		Player:     Player{Username: username},
+		Units:      units,
+		ToLocation: loc,
+	}}
+}
+
+func TestRecordRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   WorldEvent
+	}{
+		{"unknown rank", spawnEvent("alice", 2, "dragon", "americas")},
+		{"unknown location", spawnEvent("alice", 2, RankInfantry, "atlantis")},
+		{"unit ID in use", spawnEvent("alice", 1, RankInfantry, "europe")},
+		{"unaffordable spawn", spawnEvent("alice", 2, RankCavalry, "europe")},
+		{"move of an unknown unit", moveEvent("alice", "europe", 2)},
+		{"move of another player's unit", moveEvent("bob", "europe", 1)},
+		{"move out of reach", moveEvent("alice", "asia", 1)},
+		{"move without units", moveEvent("alice", "europe")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, l := testWorld(t)
+			_, _, err := l.Record(w, tt.ev)
+			if !errors.Is(err, ErrRejected) {
+				t.Fatalf("Record returned %v, want ErrRejected", err)
+			}
+			if l.seq != 2 {
+				t.Errorf("the log is at event %d, want the rejected event left out", l.seq)
+			}
+			if got := len(w.Players["alice"].Units); got != 1 {
+				t.Errorf("alice has %d units, want 1", got)
+			}
+			if got := w.gold("alice"); got != 4 {
+				t.Errorf("alice has %d gold, want 4", got)
+			}
+		})
+	}
+}
+
+func TestRecordUnitLimit(t *testing.T) {
+	w, l := testWorld(t)
+	_, _, err := l.Record(w, spawnEvent("alice", 2, RankInfantry, "europe"))
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	_, _, err = l.Record(w, spawnEvent("alice", 3, RankInfantry, "europe"))
+	if !errors.Is(err, ErrRejected) {
+		t.Errorf("spawning past the unit limit returned %v, want ErrRejected", err)
+	}
+}
+
+func TestApplyMoveIgnoresSnapshot(t *testing.T) {
+	w, l := testWorld(t)
+	ev := moveEvent("alice", "europe", 1)
+	// The snapshot claims units the server never saw spawned
+	ev.Move.Player.Units = map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		2: {ID: 2, Rank: RankArtillery, Location: "europe"},
+	}
+	_, _, err := l.Record(w, ev)
+	if err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	units := w.Players["alice"].Units
+	if len(units) != 1 || units[1].Location != "europe" || units[1].Rank != RankInfantry {
+		t.Errorf("alice's units are %v, want the infantry moved to europe", units)
+	}
+}
+
+func TestApplyTick(t *testing.T) {
+	w, _ := testWorld(t)
+	// alice owns americas and infantry has no upkeep
+	w.ApplyTick(routing.EconomyTick{Tick: 1})
+	if got := w.gold("alice"); got != 7 {
+		t.Errorf("alice has %d gold after a tick, want 7", got)
+	}
+	w.ApplyTick(routing.EconomyTick{Tick: 1})
+	if got := w.gold("alice"); got != 7 {
+		t.Errorf("alice has %d gold after a repeated tick, want 7", got)
+	}
+}
@@ -19,16 +19,22 @@ func PublishJSON[T any](ch *amqp.Channel, exchange, key string, val T) error {
 	}
 
 	// Publish the message to the exchange
+	msg := amqp.Publishing{
+		ContentType: "application/json",
+		Body:        data,
+	}
+	err = sign(key, &msg)
+	if err != nil {
+		return fmt.Errorf("failed sign JSON message: %v", err)
+	}
+
 	err = ch.PublishWithContext(
 		context.Background(),
 		exchange,
 		key,
 		false,
 		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        data,
-		},
+		msg,
 	)
 	if err != nil {
 		return fmt.Errorf("failed publish JSON message to exchange: %v", err)
@@ -48,16 +54,22 @@ func PublishGob[T any](ch *amqp.Channel, exchange, key string, val T) error {
 
 	data := buf.Bytes()
 
+	msg := amqp.Publishing{
+		ContentType: "application/gob",
+		Body:        data,
+	}
+	err = sign(key, &msg)
+	if err != nil {
+		return fmt.Errorf("failed sign gob message: %v", err)
+	}
+
 	err = ch.PublishWithContext(
 		context.Background(),
 		exchange,
 		key,
 		false,
 		false,
-		amqp.Publishing{
-			ContentType: "application/gob",
-			Body:        data,
-		},
+		msg,
 	)
 	if err != nil {
 		return fmt.Errorf("failed publish gob message to exchange: %v", err)
@@ -0,0 +1,240 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// directReplyTo is RabbitMQ's pseudo-queue for request/reply without
+// declaring a reply queue per request.
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+const requestTimeout = 10 * time.Second
+
+// replyKey is what a reply's signature covers in place of a routing key.
+// Correlation IDs are random, so a reply can not be passed off as the
+// answer to another request.
+func replyKey(correlationID string) string {
+	return "reply." + correlationID
+}
+
+// RequestJSON publishes req to the exchange and waits for a single JSON reply
+// from a Respond handler. The request is signed like any other message, so
+// a RespondJSONFrom handler knows who sent it. When messages are being
+// verified, the reply must be signed by the verifier's authority, so nobody
+// else consuming the request queue can answer it.
+func RequestJSON[Req, Resp any](conn *amqp.Connection, exchange, key string, req Req) (Resp, error) {
+	var resp Resp
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return resp, err
+	}
+	defer ch.Close()
+
+	// Direct reply-to requires consuming before publishing, in no-ack mode
+	replies, err := ch.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		return resp, fmt.Errorf("failed to consume replies: %v", err)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return resp, fmt.Errorf("failed JSON marshal request: %v", err)
+	}
+	nonce := make([]byte, 16)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return resp, fmt.Errorf("failed to generate correlation ID: %v", err)
+	}
+	id := hex.EncodeToString(nonce)
+
+	// Requests nobody is bound to receive come back as returns
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	request := amqp.Publishing{
+		ContentType:   "application/json",
+		CorrelationId: id,
+		ReplyTo:       directReplyTo,
+		Body:          data,
+	}
+	err = sign(key, &request)
+	if err != nil {
+		return resp, err
+	}
+	err = ch.PublishWithContext(
+		ctx,
+		exchange,
+		key,
+		true,
+		false,
+		request,
+	)
+	if err != nil {
+		return resp, fmt.Errorf("failed publish request: %v", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return resp, fmt.Errorf("no reply to %v request within %v", key, requestTimeout)
+		case <-returns:
+			return resp, fmt.Errorf("nobody is listening for %v requests", key)
+		case msg, ok := <-replies:
+			if !ok {
+				return resp, fmt.Errorf("reply channel closed")
+			}
+			if msg.CorrelationId != id {
+				continue
+			}
+			msg.RoutingKey = replyKey(id)
+			signedBy, err := verify(msg, routing.Transient)
+			if err == nil && signedBy != authority() {
+				err = fmt.Errorf("reply signed by %s", signedBy)
+			}
+			if err != nil {
+				return resp, fmt.Errorf("rejecting reply to %v request: %v", key, err)
+			}
+			err = json.Unmarshal(msg.Body, &resp)
+			if err != nil {
+				return resp, fmt.Errorf("failed JSON unmarshal reply: %v", err)
+			}
+			return resp, nil
+		}
+	}
+}
+
+// RespondJSON serves requests sent with RequestJSON. Each request is passed to
+// handler and its result is sent back to the requester. Requests are not
+// verified, since they may come from players who have no key yet.
+func RespondJSON[Req, Resp any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType routing.SimpleQueueType,
+	handler func(Req) Resp,
+) error {
+	return respondJSON(conn, exchange, queueName, key, queueType, nil, handler)
+}
+
+// RespondJSONFrom is RespondJSON for requests made on behalf of a player.
+// When messages are being verified, each request must be signed by
+// sender(req). Like a message on a transient queue, it must also be recent
+// and not seen before, since its requester only waits a moment for the
+// answer. Any other request is discarded unanswered.
+func RespondJSONFrom[Req, Resp any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType routing.SimpleQueueType,
+	sender func(Req) string,
+	handler func(Req) Resp,
+) error {
+	return respondJSON(conn, exchange, queueName, key, queueType, sender, handler)
+}
+
+func respondJSON[Req, Resp any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType routing.SimpleQueueType,
+	sender func(Req) string,
+	handler func(Req) Resp,
+) error {
+	ch, _, err := DeclareAndBind(
+		conn,
+		exchange,
+		queueName,
+		key,
+		queueType,
+	)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := ch.Consume(
+		queueName,
+		"",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for msg := range msgs {
+			signedBy := ""
+			if sender != nil {
+				var err error
+				signedBy, err = verify(msg, routing.Transient)
+				if err != nil {
+					log.Printf("Rejecting request on %q: %v\n", msg.RoutingKey, err)
+					msg.Nack(false, false)
+					continue
+				}
+			}
+
+			var req Req
+			err := json.Unmarshal(msg.Body, &req)
+			if err != nil {
+				log.Printf("Discarding malformed request: %v\n", err)
+				msg.Nack(false, false)
+				continue
+			}
+			if sender != nil && signedBy != "" && signedBy != sender(req) {
+				log.Printf("Rejecting request from %q made as %q\n", signedBy, sender(req))
+				msg.Nack(false, false)
+				continue
+			}
+
+			data, err := json.Marshal(handler(req))
+			if err != nil {
+				log.Printf("Failed JSON marshal reply: %v\n", err)
+				msg.Nack(false, false)
+				continue
+			}
+
+			if msg.ReplyTo != "" {
+				reply := amqp.Publishing{
+					ContentType:   "application/json",
+					CorrelationId: msg.CorrelationId,
+					Body:          data,
+				}
+				err = sign(replyKey(msg.CorrelationId), &reply)
+				if err == nil {
+					err = ch.PublishWithContext(
+						context.Background(),
+						"",
+						msg.ReplyTo,
+						false,
+						false,
+						reply,
+					)
+				}
+				if err != nil {
+					log.Printf("Failed to publish reply: %v\n", err)
+				}
+			}
+			msg.Ack(false)
+		}
+	}()
+
+	return nil
+}
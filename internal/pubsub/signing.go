@@ -0,0 +1,232 @@
+package pubsub
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	headerSigner    = "x-signer"
+	headerNonce     = "x-nonce"
+	headerSignedAt  = "x-signed-at"
+	headerSignature = "x-signature"
+)
+
+// SignatureWindow is how far a message's signing time may be from the
+// receiver's clock on a transient queue. Older messages are treated as
+// replays. Durable and retained queues exist to hold messages for a while,
+// so their readers must reject replays the nonce cache has forgotten by
+// what the messages carry, such as an ID or a version.
+const SignatureWindow = time.Minute
+
+// nonceRetention is how long a nonce is remembered after it was seen.
+const nonceRetention = 2 * SignatureWindow
+
+// Signer signs every message published by this process under Name.
+type Signer struct {
+	Name string
+	Key  ed25519.PrivateKey
+}
+
+// Verifier checks the signature of every message this process receives and
+// rejects replays of messages it has already seen. Messages that are not
+// published under a player's username, such as pauses and economy ticks,
+// must be signed by authority.
+type Verifier struct {
+	authority string
+	lookup    func(signer string) (ed25519.PublicKey, bool)
+	// unwindowed picks the routing keys exempt from the freshness window
+	unwindowed func(key string) bool
+	seen       map[string]time.Time
+	mu         *sync.Mutex
+}
+
+var (
+	signer    *Signer
+	verifier  *Verifier
+	signingMu = &sync.RWMutex{}
+)
+
+// UseSigner makes PublishJSON and PublishGob sign with s.
+func UseSigner(s *Signer) {
+	signingMu.Lock()
+	defer signingMu.Unlock()
+	signer = s
+}
+
+// UseVerifier makes SubscribeJSON and SubscribeGob discard, and so dead
+// letter, every message that v does not accept.
+func UseVerifier(v *Verifier) {
+	signingMu.Lock()
+	defer signingMu.Unlock()
+	verifier = v
+}
+
+// NewVerifier checks signatures against the public keys returned by lookup.
+func NewVerifier(authority string, lookup func(signer string) (ed25519.PublicKey, bool)) *Verifier {
+	v := &Verifier{
+		authority: authority,
+		lookup:    lookup,
+		seen:      map[string]time.Time{},
+		mu:        &sync.Mutex{},
+	}
+	go v.expireNonces()
+	return v
+}
+
+// SkipWindow exempts messages whose routing key unwindowed reports true for
+// from the freshness window on transient queues. It is for messages whose
+// handler checks their age its own way, since the window also rejects
+// senders whose clock is off by more than it.
+func (v *Verifier) SkipWindow(unwindowed func(key string) bool) {
+	v.unwindowed = unwindowed
+}
+
+// expireNonces forgets nonces once they are older than nonceRetention.
+func (v *Verifier) expireNonces() {
+	ticker := time.NewTicker(SignatureWindow)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		v.mu.Lock()
+		for id, at := range v.seen {
+			if at.Before(now.Add(-nonceRetention)) {
+				delete(v.seen, id)
+			}
+		}
+		v.mu.Unlock()
+	}
+}
+
+// sign adds the signing headers to a message about to be published to key.
+// It does nothing if no signer is in use.
+func sign(key string, msg *amqp.Publishing) error {
+	signingMu.RLock()
+	s := signer
+	signingMu.RUnlock()
+	if s == nil {
+		return nil
+	}
+
+	nonce := make([]byte, 16)
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	signedAt := time.Now().UnixNano()
+
+	if msg.Headers == nil {
+		msg.Headers = amqp.Table{}
+	}
+	msg.Headers[headerSigner] = s.Name
+	msg.Headers[headerNonce] = hex.EncodeToString(nonce)
+	msg.Headers[headerSignedAt] = signedAt
+	msg.Headers[headerSignature] = ed25519.Sign(s.Key, signedBytes(key, s.Name, hex.EncodeToString(nonce), signedAt, msg.Body))
+	return nil
+}
+
+// verify checks a delivery from a queue of queueType against the verifier
+// in use and returns who signed it. With no verifier every message is
+// accepted.
+func verify(msg amqp.Delivery, queueType routing.SimpleQueueType) (string, error) {
+	v := currentVerifier()
+	if v == nil {
+		return "", nil
+	}
+	if queueType == routing.Retained {
+		// A retained value is also delivered live to the queues bound to its
+		// key, so its nonce is remembered apart from theirs
+		return v.verify(msg, time.Now(), false, "retained/")
+	}
+	fresh := queueType == routing.Transient && (v.unwindowed == nil || !v.unwindowed(msg.RoutingKey))
+	return v.Verify(msg, time.Now(), fresh)
+}
+
+// authority returns who must sign messages without a sender, or "" if
+// messages are not being verified.
+func authority() string {
+	v := currentVerifier()
+	if v == nil {
+		return ""
+	}
+	return v.authority
+}
+
+func currentVerifier() *Verifier {
+	signingMu.RLock()
+	defer signingMu.RUnlock()
+	return verifier
+}
+
+// Verify checks that msg was signed by a known key for its routing key and
+// has not been seen before. If fresh is set, it must also have been signed
+// within SignatureWindow of now. A message the broker redelivers, after a
+// requeue or a lost connection, was seen by us before and is let through.
+func (v *Verifier) Verify(msg amqp.Delivery, now time.Time, fresh bool) (string, error) {
+	return v.verify(msg, now, fresh, "")
+}
+
+// verify is Verify with nonces remembered under scope.
+func (v *Verifier) verify(msg amqp.Delivery, now time.Time, fresh bool, scope string) (string, error) {
+	name, err := v.signedBy(msg, now, fresh)
+	if err != nil || msg.Redelivered {
+		return name, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	nonce, _ := msg.Headers[headerNonce].(string)
+	id := scope + name + "/" + nonce
+	if _, ok := v.seen[id]; ok {
+		return "", fmt.Errorf("replayed message from %s", name)
+	}
+	v.seen[id] = now
+	return name, nil
+}
+
+// signedBy checks msg's signature, and its freshness if fresh is set, and
+// returns who signed it.
+func (v *Verifier) signedBy(msg amqp.Delivery, now time.Time, fresh bool) (string, error) {
+	name, _ := msg.Headers[headerSigner].(string)
+	nonce, _ := msg.Headers[headerNonce].(string)
+	signedAt, _ := msg.Headers[headerSignedAt].(int64)
+	signature, _ := msg.Headers[headerSignature].([]byte)
+	if name == "" || nonce == "" || signedAt == 0 || signature == nil {
+		return "", errors.New("message is not signed")
+	}
+
+	key, ok := v.lookup(name)
+	if !ok {
+		return "", fmt.Errorf("no key for %s", name)
+	}
+	if !ed25519.Verify(key, signedBytes(msg.RoutingKey, name, nonce, signedAt, msg.Body), signature) {
+		return "", fmt.Errorf("bad signature from %s", name)
+	}
+
+	sent := time.Unix(0, signedAt)
+	if fresh && (sent.Before(now.Add(-SignatureWindow)) || sent.After(now.Add(SignatureWindow))) {
+		return "", fmt.Errorf("message from %s was signed at %v, outside the %v window", name, sent.Format(time.TimeOnly), SignatureWindow)
+	}
+	return name, nil
+}
+
+// signedBytes is the envelope a signature covers. The routing key is part of
+// it so a signed message can not be replayed under a different key.
+func signedBytes(key, name, nonce string, signedAt int64, body []byte) []byte {
+	var buf bytes.Buffer
+	for _, field := range []string{key, name, nonce, strconv.FormatInt(signedAt, 10)} {
+		buf.WriteString(field)
+		buf.WriteByte('\n')
+	}
+	buf.Write(body)
+	return buf.Bytes()
+}
@@ -0,0 +1,138 @@
+package pubsub
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// testKeys signs as alice and verifies against alice's key, with the server as
+// the authority.
+func testKeys(t *testing.T) *Verifier {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	UseSigner(&Signer{Name: "alice", Key: private})
+	t.Cleanup(func() { UseSigner(nil) })
+	return NewVerifier("server", func(signer string) (ed25519.PublicKey, bool) {
+		return public, signer == "alice"
+	})
+}
+
+// signed is a delivery of body published to key and signed by the signer in
+// use.
+func signed(t *testing.T, key, body string) amqp.Delivery {
+	t.Helper()
+	msg := amqp.Publishing{Body: []byte(body)}
+	err := sign(key, &msg)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return amqp.Delivery{RoutingKey: key, Headers: msg.Headers, Body: msg.Body}
+}
+
+func TestVerify(t *testing.T) {
+	const key = "game.army_moves.alice"
+	tests := []struct {
+		name   string
+		change func(msg *amqp.Delivery)
+		at     time.Duration
+		fresh  bool
+		ok     bool
+	}{
+		{"valid", func(msg *amqp.Delivery) {}, 0, true, true},
+		{"unsigned", func(msg *amqp.Delivery) { msg.Headers = nil }, 0, true, false},
+		{"changed body", func(msg *amqp.Delivery) { msg.Body = []byte("retreat") }, 0, true, false},
+		{"other routing key", func(msg *amqp.Delivery) { msg.RoutingKey = "game.army_moves.bob" }, 0, true, false},
+		{"unknown signer", func(msg *amqp.Delivery) { msg.Headers[headerSigner] = "mallory" }, 0, true, false},
+		{"changed signing time", func(msg *amqp.Delivery) {
+			msg.Headers[headerSignedAt] = msg.Headers[headerSignedAt].(int64) + 1
+		}, 0, true, false},
+		{"too old", func(msg *amqp.Delivery) {}, 2 * SignatureWindow, true, false},
+		{"too far ahead", func(msg *amqp.Delivery) {}, -2 * SignatureWindow, true, false},
+		{"old but not checked for freshness", func(msg *amqp.Delivery) {}, time.Hour, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := testKeys(t)
+			msg := signed(t, key, "attack")
+			tt.change(&msg)
+			name, err := v.Verify(msg, time.Now().Add(tt.at), tt.fresh)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Verify returned %v, want ok %v", err, tt.ok)
+			}
+			if tt.ok && name != "alice" {
+				t.Errorf("Verify says the message was signed by %q, want alice", name)
+			}
+		})
+	}
+}
+
+func TestVerifyNonces(t *testing.T) {
+	const key = "game.pause"
+	tests := []struct {
+		name  string
+		first func(v *Verifier, msg amqp.Delivery) error
+		again func(v *Verifier, msg amqp.Delivery) error
+		ok    bool
+	}{
+		{
+			name:  "replay",
+			first: verifyLive,
+			again: verifyLive,
+			ok:    false,
+		},
+		{
+			name:  "redelivery",
+			first: verifyLive,
+			again: func(v *Verifier, msg amqp.Delivery) error {
+				msg.Redelivered = true
+				return verifyLive(v, msg)
+			},
+			ok: true,
+		},
+		{
+			name:  "retained replay",
+			first: verifyRetained,
+			again: verifyRetained,
+			ok:    false,
+		},
+		{
+			name:  "retained copy of a live message",
+			first: verifyLive,
+			again: verifyRetained,
+			ok:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := testKeys(t)
+			msg := signed(t, key, "{}")
+			err := tt.first(v, msg)
+			if err != nil {
+				t.Fatalf("the first delivery was rejected: %v", err)
+			}
+			err = tt.again(v, msg)
+			if (err == nil) != tt.ok {
+				t.Errorf("the second delivery returned %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func verifyLive(v *Verifier, msg amqp.Delivery) error {
+	_, err := v.Verify(msg, time.Now(), true)
+	return err
+}
+
+func verifyRetained(v *Verifier, msg amqp.Delivery) error {
+	UseVerifier(v)
+	defer UseVerifier(nil)
+	_, err := verify(msg, routing.Retained)
+	return err
+}
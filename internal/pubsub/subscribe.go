@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"encoding/gob"
 	"encoding/json"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 	amqp "github.com/rabbitmq/amqp091-go"
@@ -27,15 +29,30 @@ func DeclareAndBind(
 	var isDurable bool
 	var isAutoDelete bool
 	var isExclusive bool
+	args := amqp.Table{
+		"x-dead-letter-exchange": routing.ExchangePerilDlx,
+	}
 	switch queueType {
 	case routing.Durable:
 		isDurable = true
 		isAutoDelete = false
 		isExclusive = false
 	case routing.Transient:
+		// Not exclusive, so the server can inspect its players' queues
 		isDurable = false
 		isAutoDelete = true
-		isExclusive = true
+		isExclusive = false
+	case routing.Retained:
+		isDurable = true
+		isAutoDelete = false
+		isExclusive = false
+		args["x-max-length"] = 1
+		args["x-overflow"] = "drop-head"
+	case routing.SingleActive:
+		isDurable = true
+		isAutoDelete = false
+		isExclusive = false
+		args["x-single-active-consumer"] = true
 	}
 	q, err := ch.QueueDeclare(
 		queueName,
@@ -43,9 +60,7 @@ func DeclareAndBind(
 		isAutoDelete,
 		isExclusive,
 		false,
-		amqp.Table{
-			"x-dead-letter-exchange": routing.ExchangePerilDlx,
-		},
+		args,
 	)
 	if err != nil {
 		return nil, amqp.Queue{}, err
@@ -61,6 +76,71 @@ func DeclareAndBind(
 	return ch, q, nil
 }
 
+// peekBackoff is how long PeekJSON waits before each retry. A peeked
+// message is held by whoever peeked it until they requeue it, so a queue
+// that looks empty may just be being peeked by someone else.
+var peekBackoff = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	400 * time.Millisecond,
+}
+
+// PeekJSON reads the message at the head of a queue without consuming it. It
+// is meant for Retained queues, where that message is the current value. The
+// returned bool is false when the queue is still empty after every retry.
+// Only the verifier's authority may set a retained value, so one signed by
+// anyone else is an error.
+func PeekJSON[T any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType routing.SimpleQueueType,
+) (T, bool, error) {
+	var obj T
+	ch, _, err := DeclareAndBind(
+		conn,
+		exchange,
+		queueName,
+		key,
+		queueType,
+	)
+	if err != nil {
+		return obj, false, err
+	}
+	defer ch.Close()
+
+	msg, ok, err := ch.Get(queueName, false)
+	for _, wait := range peekBackoff {
+		if err != nil || ok {
+			break
+		}
+		time.Sleep(wait)
+		msg, ok, err = ch.Get(queueName, false)
+	}
+	if err != nil {
+		return obj, false, err
+	}
+	if !ok {
+		return obj, false, nil
+	}
+	defer msg.Nack(false, true)
+
+	signedBy, err := verify(msg, queueType)
+	if err != nil {
+		return obj, false, fmt.Errorf("failed to verify message: %v", err)
+	}
+	if signedBy != authority() {
+		return obj, false, fmt.Errorf("message on %q was signed by %q", queueName, signedBy)
+	}
+	err = json.Unmarshal(msg.Body, &obj)
+	if err != nil {
+		return obj, false, fmt.Errorf("failed JSON unmarshal message: %v", err)
+	}
+	return obj, true, nil
+}
+
 func SubscribeJSON[T any](
 	conn *amqp.Connection,
 	exchange,
@@ -68,6 +148,22 @@ func SubscribeJSON[T any](
 	key string,
 	queueType routing.SimpleQueueType, // represents "durable" or "transient"
 	handler func(T) routing.AckType,
+) error {
+	return SubscribeJSONFrom(conn, exchange, queueName, key, queueType, nil, handler)
+}
+
+// SubscribeJSONFrom is SubscribeJSON for messages published under their
+// sender's username, the last segment of the routing key. Messages whose
+// routing key or signature names someone other than sender(msg) are
+// discarded without reaching handler.
+func SubscribeJSONFrom[T any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType routing.SimpleQueueType,
+	sender func(T) string,
+	handler func(T) routing.AckType,
 ) error {
 	// Make sure the queue exists
 	ch, _, err := DeclareAndBind(
@@ -97,26 +193,28 @@ func SubscribeJSON[T any](
 
 	go func() {
 		for msg := range msgs {
+			signedBy, err := verify(msg, queueType)
+			if err != nil {
+				log.Printf("Rejecting message on %q: %v\n", msg.RoutingKey, err)
+				msg.Nack(false, false)
+				continue
+			}
+
 			// Unmarshal deliveries
 			var obj T
 			err = json.Unmarshal(msg.Body, &obj)
 			if err != nil {
-				return
+				log.Printf("Discarding malformed message on %q: %v\n", msg.RoutingKey, err)
+				msg.Nack(false, false)
+				continue
 			}
-
-			// Send the object of T to the handler
-			ackType := handler(obj)
-			switch ackType {
-			case routing.Ack:
-				log.Println("Sending Ack")
-				msg.Ack(false)
-			case routing.NackRequeue:
-				log.Println("Sending Nack Requeue")
-				msg.Nack(false, true)
-			case routing.NackDiscard:
-				log.Println("Sending Nack Discard")
+			if !fromSender(msg, obj, signedBy, sender) {
 				msg.Nack(false, false)
+				continue
 			}
+
+			// Send the object of T to the handler
+			settle(msg, handler(obj))
 		}
 	}()
 
@@ -131,6 +229,61 @@ func SubscribeGob[T any](
 	queueType routing.SimpleQueueType,
 	handler func(T) routing.AckType,
 ) error {
+	return SubscribeGobFrom(conn, exchange, queueName, key, queueType, nil, handler)
+}
+
+// SubscribeGobFrom is SubscribeJSONFrom for gob encoded messages.
+func SubscribeGobFrom[T any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType routing.SimpleQueueType,
+	sender func(T) string,
+	handler func(T) routing.AckType,
+) error {
+	_, err := SubscribeGobDeferred(conn, exchange, queueName, key, queueType, sender, func(obj T, ack Acker) {
+		ack(handler(obj))
+	})
+	return err
+}
+
+// Acker settles a delivery once its handler is done with it.
+type Acker func(routing.AckType)
+
+// Subscription is a consumer that can be stopped before its connection is
+// closed.
+type Subscription struct {
+	ch      *amqp.Channel
+	tag     string
+	stopped chan struct{}
+}
+
+// Stop cancels the consumer and waits until every delivery it had already
+// received has been passed to its handler. Deliveries the handler has not
+// settled yet can still be settled.
+func (s *Subscription) Stop() error {
+	err := s.ch.Cancel(s.tag, false)
+	if err != nil {
+		return err
+	}
+	<-s.stopped
+	return nil
+}
+
+// SubscribeGobDeferred is SubscribeGobFrom for handlers that finish their
+// work after returning, such as batching writers. Each delivery stays
+// unacknowledged until the handler calls its Acker, which is safe to call
+// from any goroutine. The returned Subscription stops the consumer.
+func SubscribeGobDeferred[T any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType routing.SimpleQueueType,
+	sender func(T) string,
+	handler func(T, Acker),
+) (*Subscription, error) {
 	ch, _, err := DeclareAndBind(
 		conn,
 		exchange,
@@ -139,12 +292,17 @@ func SubscribeGob[T any](
 		queueType,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	sub := &Subscription{
+		ch:      ch,
+		tag:     queueName,
+		stopped: make(chan struct{}),
+	}
 	msgs, err := ch.Consume(
 		queueName,
-		"",
+		sub.tag,
 		false,
 		false,
 		false,
@@ -152,32 +310,116 @@ func SubscribeGob[T any](
 		nil,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	go func() {
+		defer close(sub.stopped)
 		for msg := range msgs {
+			signedBy, err := verify(msg, queueType)
+			if err != nil {
+				log.Printf("Rejecting message on %q: %v\n", msg.RoutingKey, err)
+				msg.Nack(false, false)
+				continue
+			}
+
 			// Decode gob
 			buffer := bytes.NewBuffer(msg.Body)
 			decoder := gob.NewDecoder(buffer)
 			var obj T
-			decoder.Decode(&obj)
-
-			// Send the object of T to the handler
-			ackType := handler(obj)
-			switch ackType {
-			case routing.Ack:
-				log.Println("Sending Ack")
-				msg.Ack(false)
-			case routing.NackRequeue:
-				log.Println("Sending Nack Requeue")
-				msg.Nack(false, true)
-			case routing.NackDiscard:
-				log.Println("Sending Nack Discard")
+			err = decoder.Decode(&obj)
+			if err != nil {
+				log.Printf("Discarding malformed message on %q: %v\n", msg.RoutingKey, err)
+				msg.Nack(false, false)
+				continue
+			}
+			if !fromSender(msg, obj, signedBy, sender) {
 				msg.Nack(false, false)
+				continue
 			}
+
+			// Send the object of T to the handler
+			handler(obj, func(ackType routing.AckType) {
+				settle(msg, ackType)
+			})
 		}
 	}()
 
-	return nil
+	return sub, nil
+}
+
+func settle(msg amqp.Delivery, ackType routing.AckType) {
+	switch ackType {
+	case routing.Ack:
+		log.Println("Sending Ack")
+		msg.Ack(false)
+	case routing.NackRequeue:
+		log.Println("Sending Nack Requeue")
+		msg.Nack(false, true)
+	case routing.NackDiscard:
+		log.Println("Sending Nack Discard")
+		msg.Nack(false, false)
+	}
+}
+
+// fromSender reports whether msg was published under the username its payload
+// claims, and signed by that player when messages are being verified. A nil
+// sender means msg is a control message, which only the verifier's authority
+// may sign.
+func fromSender[T any](msg amqp.Delivery, obj T, signedBy string, sender func(T) string) bool {
+	if sender == nil {
+		if signedBy != authority() {
+			log.Printf("Rejecting message from %q on %q\n", signedBy, msg.RoutingKey)
+			return false
+		}
+		return true
+	}
+	username, err := routing.Sender(msg.RoutingKey)
+	if err != nil || username != sender(obj) || signedBy != "" && signedBy != username {
+		log.Printf("Rejecting message from %q published as %q\n", sender(obj), msg.RoutingKey)
+		return false
+	}
+	return true
+}
+
+// QueueStatus is what a passive declare tells us about a queue. Err is set if
+// the queue could not be inspected, such as one that does not exist or is
+// exclusive to another connection.
+type QueueStatus struct {
+	Name      string
+	Messages  int
+	Consumers int
+	Err       string `json:",omitempty"`
+}
+
+// InspectQueues passively declares each queue to read its message and
+// consumer counts without creating or changing it.
+func InspectQueues(conn *amqp.Connection, names []string) []QueueStatus {
+	statuses := []QueueStatus{}
+	var ch *amqp.Channel
+	for _, name := range names {
+		status := QueueStatus{Name: name}
+		var err error
+		if ch == nil || ch.IsClosed() {
+			// A failed passive declare closes the channel
+			ch, err = conn.Channel()
+			if err != nil {
+				status.Err = err.Error()
+				statuses = append(statuses, status)
+				continue
+			}
+		}
+		q, err := ch.QueueDeclarePassive(name, false, false, false, false, nil)
+		if err != nil {
+			status.Err = err.Error()
+		} else {
+			status.Messages = q.Messages
+			status.Consumers = q.Consumers
+		}
+		statuses = append(statuses, status)
+	}
+	if ch != nil && !ch.IsClosed() {
+		ch.Close()
+	}
+	return statuses
 }
@@ -0,0 +1,95 @@
+package routing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultGameID is the game clients and servers join when none is given.
+const DefaultGameID = "default"
+
+// Key builds a routing key or queue name inside a game's namespace, so many
+// games can share one broker without cross-talk. Every part is escaped, so a
+// username can not add segments or wildcards to the key.
+func Key(gameID string, parts ...string) string {
+	segments := []string{EscapeSegment(gameID)}
+	for _, part := range parts {
+		segments = append(segments, EscapeSegment(part))
+	}
+	return strings.Join(segments, ".")
+}
+
+// Pattern is a topic binding that matches prefix for every player in a game.
+func Pattern(gameID, prefix string) string {
+	return Key(gameID, prefix) + ".*"
+}
+
+// HasPrefix reports whether key is a per-player key under prefix, in any
+// game.
+func HasPrefix(key, prefix string) bool {
+	segments := strings.Split(key, ".")
+	return len(segments) == 3 && segments[1] == EscapeSegment(prefix)
+}
+
+// Sender returns the unescaped last segment of a routing key, which is the
+// username of the player that published it for per-player keys.
+func Sender(key string) (string, error) {
+	segments := strings.Split(key, ".")
+	return UnescapeSegment(segments[len(segments)-1])
+}
+
+// EscapeSegment makes s safe to use as a single routing key segment. Letters,
+// digits, '-' and '_' are kept and every other byte is written as '~' and
+// two hex digits, so '.', '*' and '#' can never reach the broker.
+func EscapeSegment(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isSegmentByte(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "~%02x", c)
+		}
+	}
+	return b.String()
+}
+
+func UnescapeSegment(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case isSegmentByte(c):
+			b.WriteByte(c)
+		case c == '~' && i+2 < len(s):
+			v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("bad escape in segment %q: %v", s, err)
+			}
+			b.WriteByte(byte(v))
+			i += 2
+		default:
+			return "", fmt.Errorf("segment %q is not escaped", s)
+		}
+	}
+	return b.String(), nil
+}
+
+func isSegmentByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+}
+
+// ValidateGameID makes sure a game ID can be used as a single routing key
+// segment.
+func ValidateGameID(gameID string) error {
+	if gameID == "" || len(gameID) > 32 {
+		return fmt.Errorf("game ID must be between 1 and 32 characters")
+	}
+	for _, r := range gameID {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return fmt.Errorf("game ID %q may only contain lowercase letters, digits, '-' and '_'", gameID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package routing
+
+import "testing"
+
+func TestEscapeSegment(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "alice_01-x", "alice_01-x"},
+		{"empty", "", ""},
+		{"dot", "a.b", "a~2eb"},
+		{"wildcards", "*#", "~2a~23"},
+		{"escape character", "~", "~7e"},
+		{"unicode", "é", "~c3~a9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EscapeSegment(tt.in)
+			if got != tt.want {
+				t.Errorf("EscapeSegment(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			back, err := UnescapeSegment(got)
+			if err != nil || back != tt.in {
+				t.Errorf("UnescapeSegment(%q) = %q, %v, want %q", got, back, err, tt.in)
+			}
+		})
+	}
+}
+
+func TestUnescapeSegmentRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"unescaped dot", "a.b"},
+		{"unescaped wildcard", "*"},
+		{"truncated escape", "a~2"},
+		{"lone escape", "~"},
+		{"bad hex", "~zz"},
+		{"signed hex", "~+1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnescapeSegment(tt.in)
+			if err == nil {
+				t.Errorf("UnescapeSegment(%q) = %q, want an error", tt.in, got)
+			}
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	key := Key("game", "army_moves", "a.b*")
+	if key != "game.army_moves.a~2eb~2a" {
+		t.Fatalf("Key = %q", key)
+	}
+	if !HasPrefix(key, "army_moves") {
+		t.Errorf("HasPrefix(%q, army_moves) = false", key)
+	}
+	sender, err := Sender(key)
+	if err != nil || sender != "a.b*" {
+		t.Errorf("Sender(%q) = %q, %v, want %q", key, sender, err, "a.b*")
+	}
+}
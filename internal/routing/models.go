@@ -2,16 +2,44 @@ package routing
 
 import "time"
 
+// PlayingState is whether a game is paused. Version increases with every
+// change, so a state older than one already seen can be ignored.
 type PlayingState struct {
 	IsPaused bool
+	Version  int64
+	Reason   string
+	// ResumeAt is when a timed pause ends. It is zero for a pause that lasts
+	// until the server resumes the game.
+	ResumeAt time.Time
+	// PausingAt is set to warn that a scheduled pause starts at that time.
+	PausingAt time.Time
 }
 
+type EconomyTick struct {
+	Tick        int
+	CurrentTime time.Time
+}
+
+// GameLog is a line in a game's log. ID is set by the publisher and stays
+// the same if the log is redelivered.
 type GameLog struct {
+	ID          string
+	GameID      string
+	Event       string
 	CurrentTime time.Time
 	Message     string
 	Username    string
 }
 
+// Game log events. Logs from clients that do not set one are messages.
+// Checkpoints are written by the server's log writer.
+const (
+	LogEventMessage    = "message"
+	LogEventBattle     = "battle"
+	LogEventGameOver   = "game_over"
+	LogEventCheckpoint = "checkpoint"
+)
+
 type AckType int
 
 const (
@@ -24,5 +52,13 @@ type SimpleQueueType int
 
 const (
 	Durable SimpleQueueType = iota
+	// Transient is a queue that is deleted once its last consumer is gone,
+	// and on a broker restart.
 	Transient
+	// Retained is a durable queue that only keeps the last message published
+	// to it, so late subscribers can read the current value.
+	Retained
+	// SingleActive is a durable queue that only one of its consumers reads
+	// at a time. The others stand by and one takes over if it goes away.
+	SingleActive
 )
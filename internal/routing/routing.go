@@ -3,11 +3,39 @@ package routing
 const (
 	ArmyMovesPrefix = "army_moves"
 
-	WarRecognitionsPrefix = "war"
+	ArmySpawnsPrefix = "army_spawns"
+
+	BattlesPrefix = "battles"
+
+	OwnershipPrefix = "ownership"
 
 	PauseKey = "pause"
 
+	PauseStateKey = "pause_state"
+
 	GameLogSlug = "game_logs"
+
+	RulesetKey = "ruleset"
+
+	EconomyTickKey = "economy_tick"
+
+	GameOverKey = "game_over"
+
+	LobbyKey = "lobby"
+
+	RegistryKey = "usernames"
+
+	MatchStartKey = "match_start"
+
+	PresencePrefix = "presence"
+
+	PresenceChangesKey = "presence_changes"
+
+	SessionKey = "session"
+
+	AnnouncementKey = "announcement"
+
+	KickKey = "kick"
 )
 
 const (
@@ -15,3 +43,18 @@ const (
 	ExchangePerilTopic  = "peril_topic"
 	ExchangePerilDlx    = "peril_dlx"
 )
+
+// PlayerQueuePrefixes are the queues every client declares for itself, named
+// Key(gameID, prefix, username).
+var PlayerQueuePrefixes = []string{
+	PauseKey,
+	EconomyTickKey,
+	GameOverKey,
+	OwnershipPrefix,
+	BattlesPrefix,
+	ArmyMovesPrefix,
+	PresenceChangesKey,
+	MatchStartKey,
+	AnnouncementKey,
+	KickKey,
+}